@@ -7,6 +7,9 @@ require (
 	github.com/flowchartsman/retry v0.0.0-20190826180120-7c62e13dbbeb
 	github.com/gofrs/flock v0.8.0
 	github.com/hashicorp/go-version v1.2.1
+	github.com/klauspost/compress v1.11.13
 	github.com/sirupsen/logrus v1.2.0
 	github.com/spf13/cobra v1.1.0
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -9,7 +9,8 @@ import (
 func init() {
 	log.SetOutput(os.Stderr)
 	log.SetLevel(log.InfoLevel)
-	log.SetFormatter(&log.TextFormatter{})
+	// Until flags are parsed, only NO_COLOR can turn colors off
+	log.SetFormatter(&log.TextFormatter{DisableColors: os.Getenv("NO_COLOR") != ""})
 }
 
 func main() {
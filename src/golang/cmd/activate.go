@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/conda-incubator/ensureconda/src/golang/ensureconda"
+	"strings"
+)
+
+var activateShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// quoteShells are the shells paths can be quoted for with --shell-quote.
+var quoteShells = []string{"sh", "bash", "zsh", "fish", "powershell", "nushell"}
+
+// shellQuoter returns a function quoting a word for shell.
+func shellQuoter(shell string) (func(string) string, error) {
+	switch shell {
+	case "sh", "bash", "zsh":
+		return quotePosix, nil
+	case "fish":
+		return quoteFish, nil
+	case "powershell":
+		return quotePowershell, nil
+	case "nushell":
+		return quoteNushell, nil
+	default:
+		return nil, fmt.Errorf("unknown shell %q, expected one of %v", shell, quoteShells)
+	}
+}
+
+// activateSnippet returns shell code that sets up activation with executable, the
+// resolved executable of the given flavor, and activates prefix if given.  It is meant to
+// be evaluated by the caller, e.g. eval "$(ensureconda --print-activate=bash)".
+func activateSnippet(flavor ensureconda.Flavor, executable string, shell string, prefix string) (string, error) {
+	quote, err := shellQuoter(shell)
+	if err != nil || shell == "sh" || shell == "nushell" {
+		return "", fmt.Errorf("unknown shell %q, expected one of %v", shell, activateShells)
+	}
+
+	// micromamba and mamba 2 generate their own hook, conda and conda-standalone use
+	// conda's shell.<shell> hook
+	var hook []string
+	activate := "conda"
+	switch flavor {
+	case ensureconda.Micromamba, ensureconda.Mamba:
+		hook = []string{quote(executable), "shell", "hook", "-s", shell}
+		activate = string(flavor)
+	default:
+		hook = []string{quote(executable), "shell." + shell, "hook"}
+	}
+
+	var lines []string
+	switch shell {
+	case "fish":
+		lines = append(lines, strings.Join(hook, " ")+" | source")
+	case "powershell":
+		lines = append(lines, "(& "+strings.Join(hook, " ")+") | Out-String | Invoke-Expression")
+	default:
+		lines = append(lines, `eval "$(`+strings.Join(hook, " ")+`)"`)
+	}
+	if prefix != "" {
+		lines = append(lines, activate+" activate "+quote(prefix))
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+func quotePosix(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func quoteFish(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func quotePowershell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// quoteNushell uses a raw string, r#'...'#, when s holds a single quote, as nushell's
+// single-quoted strings can't escape one.
+func quoteNushell(s string) string {
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'"
+	}
+	hashes := "#"
+	for strings.Contains(s, "'"+hashes) {
+		hashes += "#"
+	}
+	return "r" + hashes + "'" + s + "'" + hashes
+}
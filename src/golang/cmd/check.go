@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/conda-incubator/ensureconda/src/golang/ensureconda"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the executables that would be used, without downloading anything",
+	Long: `Find the executable of each flavor in the data directory and on PATH, check its version and
+that it can run "info --json", and print whether it passed.  Exits non-zero when the
+executable ensureconda would use is broken, or when there is none.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := applyConfigFile(cmd); err != nil {
+			er(err)
+		}
+		opts := searchOptions(cmd, installOptions(cmd))
+		opts.NoInstall = true
+		setupLoggingFromFlags(cmd)
+		ctx, cancel, timeout := timeoutContext(cmd)
+		defer cancel()
+
+		checks, err := ensureconda.Check(ctx, opts)
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, c := range checks {
+			status, detail := "ok", ""
+			if c.Err != nil {
+				status, detail = "FAIL", c.Err.Error()
+			}
+			if c.Executable == "" {
+				status, detail = "missing", ""
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Flavor, status, ensureconda.VersionString(c.Version), c.Executable, detail)
+		}
+		w.Flush()
+		exitOnTimeout(ctx, err, timeout, nil)
+		if errors.Is(err, ensureconda.ErrNotFound) {
+			log.Error("no usable executable found")
+			os.Exit(1)
+		}
+		if err != nil {
+			er(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+}
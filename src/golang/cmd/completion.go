@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/conda-incubator/ensureconda/src/golang/ensureconda"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish|powershell",
+	Short: "Print a shell completion script",
+	Long: `Print a completion script for ensureconda to stdout, e.g.
+
+  source <(ensureconda completion bash)
+  ensureconda completion zsh > "${fpath[1]}/_ensureconda"
+  ensureconda completion fish > ~/.config/fish/completions/ensureconda.fish
+  ensureconda completion powershell | Out-String | Invoke-Expression`,
+	ValidArgs:             []string{"bash", "zsh", "fish", "powershell"},
+	Args:                  cobra.ExactValidArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			err = rootCmd.GenBashCompletion(os.Stdout)
+		case "zsh":
+			err = rootCmd.GenZshCompletion(os.Stdout)
+		case "fish":
+			err = rootCmd.GenFishCompletion(os.Stdout, true)
+		case "powershell":
+			err = rootCmd.GenPowerShellCompletion(os.Stdout)
+		}
+		if err != nil {
+			er(err)
+		}
+	},
+}
+
+// completeWords completes a flag to one of words, never to a file name.
+func completeWords(words ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return words, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+func registerCompletions() error {
+	completions := map[string][]string{
+		"only":                    flavorFlags,
+		"conda-standalone-source": {ensureconda.SourceChannel, ensureconda.SourceGitHub},
+		"data-dir-search":         {ensureconda.DataDirFirst, ensureconda.DataDirLast, ensureconda.DataDirNever},
+		"log-format":              {"text", "json"},
+		"platform":                ensureconda.PlatformSubdirs(),
+		"print-activate":          activateShells,
+		"shell-quote":             quoteShells,
+		"verbosity":               {"0", "1", "2", "3"},
+		"log-file-verbosity":      {"0", "1", "2", "3"},
+	}
+	for flag, words := range completions {
+		if err := rootCmd.RegisterFlagCompletionFunc(flag, completeWords(words...)); err != nil {
+			return fmt.Errorf("--%s: %w", flag, err)
+		}
+	}
+	for _, flag := range []string{"data-dir", "lock-dir", "keep-archive", "bin-layout", "prefix"} {
+		if err := rootCmd.MarkPersistentFlagDirname(flag); err != nil {
+			return fmt.Errorf("--%s: %w", flag, err)
+		}
+	}
+	for _, flag := range []string{"ca-bundle", "config", "log-file"} {
+		if err := rootCmd.MarkPersistentFlagFilename(flag); err != nil {
+			return fmt.Errorf("--%s: %w", flag, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd)
+}
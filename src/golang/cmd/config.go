@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// configFileNames lists where a config file is looked for when --config isn't given, in
+// order of precedence.
+func configFileNames() []string {
+	names := []string{".ensureconda.yaml"}
+	if dir, err := os.UserConfigDir(); err == nil {
+		names = append(names, filepath.Join(dir, "ensureconda", "config.yaml"))
+	}
+	return names
+}
+
+// applyConfigFile sets flags not given on the command line from a YAML config file whose
+// keys are flag names, e.g.
+//
+//	data-dir: /opt/ensureconda
+//	no-mamba: true
+//	conda-standalone-source: [channel, github]
+//
+// A flag given on the command line also overrides the config value of its --no- pair.
+func applyConfigFile(cmd *cobra.Command) error {
+	configFile, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return err
+	}
+	if configFile == "" {
+		for _, name := range configFileNames() {
+			if _, err := os.Stat(name); err == nil {
+				configFile = name
+				break
+			}
+		}
+	}
+	if configFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	var config map[string]interface{}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s: %w", configFile, err)
+	}
+	for name, value := range config {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || name == "config" {
+			return fmt.Errorf("%s: unknown option %q", configFile, name)
+		}
+		if flag.Changed || pairChanged(cmd, name) {
+			continue
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			if _, nested := v.(map[interface{}]interface{}); nested {
+				return fmt.Errorf("%s: option %q must be a value or a list of values", configFile, name)
+			}
+			if err := flag.Value.Set(fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("%s: option %q: %w", configFile, name, err)
+			}
+		}
+		flag.Changed = true
+	}
+	return nil
+}
+
+// pairChanged reports whether the other flag of a --flag/--no-flag pair was given.
+func pairChanged(cmd *cobra.Command, name string) bool {
+	pairName := "no-" + name
+	if strings.HasPrefix(name, "no-") {
+		pairName = strings.TrimPrefix(name, "no-")
+	}
+	pair := cmd.Flags().Lookup(pairName)
+	return pair != nil && pair.Changed
+}
@@ -1,14 +1,20 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"github.com/Wessie/appdirs"
-	"github.com/hashicorp/go-version"
+	"github.com/conda-incubator/ensureconda/src/golang/ensureconda"
 	"github.com/spf13/cobra"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -17,170 +23,548 @@ var (
 	// Used for flags.
 
 	rootCmd = &cobra.Command{
-		Use:   "ensureconda",
+		Use:   "ensureconda [--prefix PREFIX [PACKAGE_SPEC...]]",
 		Short: "",
 		Long:  ``,
+		// package specs, which cobra would otherwise take for unknown subcommands
+		Args: cobra.ArbitraryArgs,
 		Run: func(cmd *cobra.Command, args []string) {
-			mamba, err := evaluateFlagPair(cmd, "mamba")
+			if err := applyConfigFile(cmd); err != nil {
+				er(err)
+			}
+			dataDir, err := cmd.Flags().GetString("data-dir")
 			if err != nil {
 				panic(err)
 			}
-			micromamba, err := evaluateFlagPair(cmd, "micromamba")
+			quote, err := quoteFromFlags(cmd)
 			if err != nil {
+				er(err)
+			}
+			terminator := "\n"
+			if null, err := cmd.Flags().GetBool("null"); err != nil {
 				panic(err)
+			} else if null {
+				terminator = "\x00"
 			}
-			conda, err := evaluateFlagPair(cmd, "conda")
+			printDataDir, err := cmd.Flags().GetBool("print-data-dir")
 			if err != nil {
 				panic(err)
 			}
-			condaExe, err := evaluateFlagPair(cmd, "conda-exe")
+			if printDataDir {
+				if dataDir == "" {
+					dataDir = ensureconda.DefaultDataDir()
+				}
+				fmt.Print(quote(dataDir) + terminator)
+				os.Exit(0)
+			}
+			printPlatformSubdir, err := cmd.Flags().GetBool("print-platform-subdir")
 			if err != nil {
 				panic(err)
 			}
+			if printPlatformSubdir {
+				subdir := ensureconda.PlatformSubdir()
+				if subdir == "" {
+					subdir = "unsupported"
+				}
+				fmt.Printf("subdir: %s\nGOOS: %s\nGOARCH: %s\n", subdir, runtime.GOOS, runtime.GOARCH)
+				os.Exit(0)
+			}
+
+			opts := searchOptions(cmd, installOptions(cmd))
 			noInstall, err := cmd.Flags().GetBool("no-install")
 			if err != nil {
 				panic(err)
 			}
+			noInstallMicromamba, err := cmd.Flags().GetBool("no-install-micromamba")
+			if err != nil {
+				panic(err)
+			}
+			noInstallCondaExe, err := cmd.Flags().GetBool("no-install-conda-exe")
+			if err != nil {
+				panic(err)
+			}
+			dryRun, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				panic(err)
+			}
+			forceInstall, err := cmd.Flags().GetBool("force-install")
+			if err != nil {
+				panic(err)
+			}
+			preferSystemConda, err := cmd.Flags().GetBool("prefer-system-conda")
+			if err != nil {
+				panic(err)
+			}
+			preferSystem, err := cmd.Flags().GetBool("prefer-system")
+			if err != nil {
+				panic(err)
+			}
+			binLayout, err := cmd.Flags().GetString("bin-layout")
+			if err != nil {
+				panic(err)
+			}
+			jsonOutput, err = cmd.Flags().GetBool("json")
+			if err != nil {
+				panic(err)
+			}
 
-			verbosity, err := cmd.Flags().GetInt("verbosity")
-			switch verbosity {
-			case 3:
-				log.SetLevel(log.TraceLevel)
-			case 2:
-				log.SetLevel(log.DebugLevel)
-			case 1:
-				log.SetLevel(log.InfoLevel)
-			case 0:
-				log.SetLevel(log.WarnLevel)
-			default:
-				log.SetLevel(log.InfoLevel)
-			}
-
-			executable, err := EnsureConda(mamba, micromamba, conda, condaExe, true)
-			if executable != "" {
-				log.Debugf("Found executable %s", executable)
-				fmt.Print(executable)
-				os.Exit(0)
+			setupLoggingFromFlags(cmd)
+
+			foreignPlatform := opts.Platform != "" && opts.Platform != ensureconda.PlatformSubdir()
+			prefix, err := cmd.Flags().GetString("prefix")
+			if err != nil {
+				panic(err)
+			}
+			if prefix == "" && len(args) > 0 {
+				er("package specs can only be given together with --prefix")
+			}
+			if prefix != "" && foreignPlatform {
+				er("--prefix needs an executable for this host, not --platform " + opts.Platform)
+			}
+			if prefix != "" && opts.DownloadOnly {
+				er("--prefix runs the executable, which --download-only doesn't")
+			}
+			printActivate, err := cmd.Flags().GetString("print-activate")
+			if err != nil {
+				panic(err)
+			}
+			if _, err := activateSnippet("", "", printActivate, ""); printActivate != "" && err != nil {
+				er(err)
+			}
+			if printActivate != "" && (dryRun || opts.DownloadOnly || foreignPlatform) {
+				er("--print-activate needs an executable for this host, which --dry-run, --download-only and --platform don't provide")
 			}
-			if !noInstall {
-				log.Debugf("Attempting to install")
-				executable, err = EnsureConda(mamba, micromamba, conda, condaExe, noInstall)
-				if err != nil {
-					er(err)
+			if printActivate != "" && jsonOutput {
+				er("--print-activate and --json are mutually exclusive")
+			}
+
+			ctx, cancel, timeout := timeoutContext(cmd)
+			defer cancel()
+
+			opts.NoInstall = noInstall
+			opts.NoInstallMicromamba = noInstallMicromamba
+			opts.NoInstallCondaStandalone = noInstallCondaExe
+			opts.DryRun = dryRun
+			opts.ForceInstall = forceInstall
+			opts.PreferSystemConda = preferSystemConda
+			opts.PreferSystem = preferSystem
+			opts.BinLayoutDir = binLayout
+			result, err := ensureconda.Resolve(ctx, opts)
+			exitOnTimeout(ctx, err, timeout, func(timeoutErr error) {
+				if jsonOutput {
+					printJSONError(timeoutErr)
 				}
-				if executable != "" {
-					log.Debugf("Found executable after installing %s", executable)
-					fmt.Print(executable)
-					os.Exit(0)
+			})
+			if errors.Is(err, ensureconda.ErrNotFound) {
+				if err != ensureconda.ErrNotFound {
+					log.Warn(err)
 				}
+				if jsonOutput {
+					printJSONError(err)
+				}
+				os.Exit(1)
+			}
+			if err != nil {
+				er(err)
 			}
-			os.Exit(1)
+			if result.Planned != nil {
+				printPlan(result.Planned)
+			}
+			printResult(result, prefix, args, printActivate, quote, terminator)
 		},
 	}
 )
 
-const DefaultMinMambaVersion = "0.7.3"
-const DefaultMinCondaVersion = "4.8.2"
+// timeoutExitCode is the exit status when --timeout expires, matching timeout(1).
+const timeoutExitCode = 124
+
+// exitOnTimeout exits with timeoutExitCode when err is due to --timeout expiring, after
+// logging it and passing the timeout to report, when given, e.g. to print it as JSON.
+func exitOnTimeout(ctx context.Context, err error, timeout time.Duration, report func(error)) {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return
+	}
+	log.Errorf("timed out after %s: %v", timeout, err)
+	if report != nil {
+		report(fmt.Errorf("timed out after %s: %w", timeout, ctx.Err()))
+	}
+	os.Exit(timeoutExitCode)
+}
 
-var TestSitePath string
+// keepArchiveInDataDir is the value of a bare --keep-archive, standing for the data
+// directory, which is only known once every flag and the config file have been read.
+const keepArchiveInDataDir = "<data-dir>"
 
-func sitePath() string {
-	if TestSitePath != "" {
-		return TestSitePath
+// installOptions returns the options shared by every command that installs micromamba or
+// conda-standalone, read from the flags of cmd.
+func installOptions(cmd *cobra.Command) ensureconda.Options {
+	dataDir, err := cmd.Flags().GetString("data-dir")
+	if err != nil {
+		panic(err)
+	}
+	selfTest, err := cmd.Flags().GetBool("self-test")
+	if err != nil {
+		panic(err)
+	}
+	downloadOnly, err := cmd.Flags().GetBool("download-only")
+	if err != nil {
+		panic(err)
+	}
+	keepArchiveDir, err := cmd.Flags().GetString("keep-archive")
+	if err != nil {
+		panic(err)
+	}
+	lockDir, err := cmd.Flags().GetString("lock-dir")
+	if err != nil {
+		panic(err)
+	}
+	lockTimeout, err := cmd.Flags().GetDuration("lock-timeout")
+	if err != nil {
+		panic(err)
+	}
+	fileLockRetries, err := cmd.Flags().GetInt("file-lock-retries")
+	if err != nil {
+		panic(err)
+	}
+	fileLockMaxDelay, err := cmd.Flags().GetDuration("file-lock-max-delay")
+	if err != nil {
+		panic(err)
+	}
+	platform, err := cmd.Flags().GetString("platform")
+	if err != nil {
+		panic(err)
+	}
+	micromambaChannel, err := cmd.Flags().GetString("micromamba-channel")
+	if err != nil {
+		panic(err)
+	}
+	micromambaMirrors, err := cmd.Flags().GetStringSlice("micromamba-mirrors")
+	if err != nil {
+		panic(err)
+	}
+	socksProxy, err := cmd.Flags().GetString("socks-proxy")
+	if err != nil {
+		panic(err)
+	}
+	authHeaders, err := cmd.Flags().GetStringArray("auth-header")
+	if err != nil {
+		panic(err)
+	}
+	token, err := cmd.Flags().GetString("token")
+	if err != nil {
+		panic(err)
+	}
+	caBundle, err := cmd.Flags().GetString("ca-bundle")
+	if err != nil {
+		panic(err)
+	}
+	userAgent, err := cmd.Flags().GetString("user-agent")
+	if err != nil {
+		panic(err)
+	}
+	offline, err := cmd.Flags().GetBool("offline")
+	if err != nil {
+		panic(err)
+	}
+	insecure, err := cmd.Flags().GetBool("insecure")
+	if err != nil {
+		panic(err)
+	}
+	verifyCodesign, err := cmd.Flags().GetBool("verify-codesign")
+	if err != nil {
+		panic(err)
 	}
-	return appdirs.UserDataDir("ensure-conda", "", "", false)
+	condaStandaloneVersion, err := cmd.Flags().GetString("conda-standalone-version")
+	if err != nil {
+		panic(err)
+	}
+	condaStandaloneMinBuildNumber, err := cmd.Flags().GetInt("conda-standalone-min-build-number")
+	if err != nil {
+		panic(err)
+	}
+	condaStandalonePackage, err := cmd.Flags().GetString("conda-standalone-package")
+	if err != nil {
+		panic(err)
+	}
+	condaStandaloneSources, err := cmd.Flags().GetStringSlice("conda-standalone-source")
+	if err != nil {
+		panic(err)
+	}
+	allowOnedir, err := cmd.Flags().GetBool("allow-onedir")
+	if err != nil {
+		panic(err)
+	}
+	excludeBuilds, err := cmd.Flags().GetString("exclude-builds")
+	if err != nil {
+		panic(err)
+	}
+	installModeFlag, err := cmd.Flags().GetString("install-mode")
+	if err != nil {
+		panic(err)
+	}
+	installMode, err := strconv.ParseUint(installModeFlag, 8, 32)
+	if err != nil {
+		er(fmt.Errorf("--install-mode %q is not an octal mode such as 0775", installModeFlag))
+	}
+	mambaVersionSpec, err := cmd.Flags().GetString("mamba-version")
+	if err != nil {
+		panic(err)
+	}
+	condaVersionSpec, err := cmd.Flags().GetString("conda-version")
+	if err != nil {
+		panic(err)
+	}
+
+	opts := ensureconda.Options{
+		SelfTest:         selfTest,
+		DownloadOnly:     downloadOnly,
+		DataDir:          dataDir,
+		LockDir:          lockDir,
+		LockTimeout:      lockTimeout,
+		FileLockRetries:  fileLockRetries,
+		FileLockMaxDelay: fileLockMaxDelay,
+
+		MambaVersionSpec: mambaVersionSpec,
+		CondaVersionSpec: condaVersionSpec,
+
+		Platform:                      platform,
+		CondaStandaloneVersion:        condaStandaloneVersion,
+		CondaStandaloneMinBuildNumber: condaStandaloneMinBuildNumber,
+		CondaStandaloneSources:        condaStandaloneSources,
+		CondaStandalonePackage:        condaStandalonePackage,
+		AllowOnedir:                   allowOnedir,
+		ExcludeBuilds:                 excludeBuilds,
+		MicromambaChannel:             micromambaChannel,
+		MicromambaMirrors:             micromambaMirrors,
+		SocksProxy:                    socksProxy,
+		CABundle:                      caBundle,
+		Insecure:                      insecure,
+		Offline:                       offline,
+		VerifyCodesign:                verifyCodesign,
+		InstallMode:                   os.FileMode(installMode),
+		KeepArchiveDir:                keepArchiveDir,
+		AuthHeaders:                   authHeaders,
+		Token:                         token,
+		UserAgent:                     userAgent,
+		Logger:                        log.StandardLogger(),
+	}
+	if opts.KeepArchiveDir == keepArchiveInDataDir {
+		opts.KeepArchiveDir = ensureconda.DataDir(opts)
+	}
+	return opts
 }
 
-func EnsureConda(mamba bool, micromamba bool, conda bool, condaStandalone bool, noInstall bool) (string, error) {
-	var executable string
-	dataDir := sitePath()
-	minMambaVersion, _ := version.NewVersion(DefaultMinMambaVersion)
-	minCondaVersion, _ := version.NewVersion(DefaultMinCondaVersion)
-
-	mambaVersionCheck := executableHasMinVersion(minMambaVersion, "mamba")
-	microMambaVersionCheck := executableHasMinVersion(minMambaVersion, "")
-	condaVersionCheck := executableHasMinVersion(minCondaVersion, "conda")
-
-	if mamba {
-		log.Debug("Checking for mamba")
-		executable, _ = ResolveExecutable("mamba", dataDir, mambaVersionCheck)
-		if executable != "" {
-			return executable, nil
-		}
+// searchOptions adds the flavors to search for and where to search for them, read from the
+// flags of cmd, to opts.
+func searchOptions(cmd *cobra.Command, opts ensureconda.Options) ensureconda.Options {
+	mamba, err := evaluateFlagPair(cmd, "mamba")
+	if err != nil {
+		panic(err)
 	}
-	if micromamba {
-		log.Debug("Checking for micromamba")
-		executable, _ = ResolveExecutable("micromamba", dataDir, microMambaVersionCheck)
-		if executable != "" {
-			return executable, nil
+	micromamba, err := evaluateFlagPair(cmd, "micromamba")
+	if err != nil {
+		panic(err)
+	}
+	conda, err := evaluateFlagPair(cmd, "conda")
+	if err != nil {
+		panic(err)
+	}
+	condaExe, err := evaluateFlagPair(cmd, "conda-exe")
+	if err != nil {
+		panic(err)
+	}
+	only, err := onlyFlavor(cmd)
+	if err != nil {
+		er(err)
+	}
+	if only != "" {
+		mamba = only == "mamba"
+		micromamba = only == "micromamba"
+		conda = only == "conda"
+		condaExe = only == "conda-exe"
+	}
+	noShimFiltering, err := cmd.Flags().GetBool("no-shim-filtering")
+	if err != nil {
+		panic(err)
+	}
+	noPathSearch, err := cmd.Flags().GetBool("no-path-search")
+	if err != nil {
+		panic(err)
+	}
+	dataDirSearch, err := cmd.Flags().GetString("data-dir-search")
+	if err != nil {
+		panic(err)
+	}
+	probeConcurrency, err := cmd.Flags().GetInt("probe-concurrency")
+	if err != nil {
+		panic(err)
+	}
+
+	opts.Mamba = mamba
+	opts.Micromamba = micromamba
+	opts.Conda = conda
+	opts.CondaStandalone = condaExe
+	opts.NoShimFiltering = noShimFiltering
+	opts.NoPathSearch = noPathSearch
+	opts.DataDirSearch = dataDirSearch
+	opts.ProbeConcurrency = probeConcurrency
+	return opts
+}
+
+// timeoutContext returns the context of cmd bounded by --timeout, if given.
+func timeoutContext(cmd *cobra.Command) (context.Context, context.CancelFunc, time.Duration) {
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		panic(err)
+	}
+	if timeout <= 0 {
+		return cmd.Context(), func() {}, timeout
+	}
+	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	return ctx, cancel, timeout
+}
+
+// quoteFromFlags returns how paths are quoted for the shell given with --shell-quote, which
+// by default they aren't.
+func quoteFromFlags(cmd *cobra.Command) (func(string) string, error) {
+	shell, err := cmd.Flags().GetString("shell-quote")
+	if err != nil {
+		panic(err)
+	}
+	if shell == "" {
+		return func(s string) string { return s }, nil
+	}
+	return shellQuoter(shell)
+}
+
+// printResult prints the resolved executable, quoted with quote and followed by terminator,
+// and exits.  When a prefix is requested the executable is first used to create an
+// environment there, and the prefix is printed instead.  When an activation shell is given,
+// shell code activating the result is printed instead.
+func printResult(result ensureconda.Result, prefix string, specs []string, activateShell string, quote func(string) string, terminator string) {
+	if prefix != "" {
+		// Every output reports the prefix as the absolute path it was created at
+		absPrefix, err := filepath.Abs(prefix)
+		if err != nil {
+			er(err)
 		}
-		if !noInstall {
-			exe, err := InstallMicromamba()
-			if err != nil {
-				return "", err
-			}
-			if valid, _ := microMambaVersionCheck(exe); valid {
-				return exe, nil
-			}
+		prefix = absPrefix
+		if err := CreatePrefix(result.Executable, prefix, specs); err != nil {
+			er(err)
 		}
 	}
-	if conda {
-		log.Debug("Checking for conda")
-		// TODO: check $CONDA_EXE
-		executable, _ = ResolveExecutable("conda", dataDir, condaVersionCheck)
-		if executable != "" {
-			return executable, nil
+	if jsonOutput {
+		out := jsonResult{Executable: result.Executable, Flavor: string(result.Flavor)}
+		if result.Version != nil {
+			out.Version = result.Version.String()
 		}
+		out.Prefix = prefix
+		printJSON(out)
+		os.Exit(0)
 	}
-	if condaStandalone {
-		log.Debug("Checking for conda_standalone")
-		executable, _ = ResolveExecutable("conda_standalone", dataDir, condaVersionCheck)
-		if executable != "" {
-			return executable, nil
-		}
-		if !noInstall {
-			exe, err := InstallCondaStandalone()
-			if err != nil {
-				return "", err
-			}
-
-			if valid, _ := condaVersionCheck(exe); valid {
-				return exe, nil
-			}
+	if activateShell != "" {
+		snippet, err := activateSnippet(result.Flavor, result.Executable, activateShell, prefix)
+		if err != nil {
+			er(err)
 		}
+		fmt.Print(snippet)
+		os.Exit(0)
 	}
-
-	return "", nil
+	if prefix != "" {
+		fmt.Print(quote(prefix) + terminator)
+		os.Exit(0)
+	}
+	fmt.Print(quote(result.Executable) + terminator)
+	os.Exit(0)
 }
 
-type ArchSpec struct {
-	os   string
-	arch string
+// printPlan reports the install a dry run would have performed and exits.
+func printPlan(plan *ensureconda.PlannedInstall) {
+	if jsonOutput {
+		printJSON(struct {
+			Planned jsonPlan `json:"planned"`
+		}{jsonPlan{Flavor: string(plan.Flavor), Url: plan.Url, Version: plan.Version, BuildNumber: plan.BuildNumber}})
+		os.Exit(0)
+	}
+	if plan.Version != "" {
+		fmt.Printf("would install %s %s (build %d) from %s\n", plan.Flavor, plan.Version, plan.BuildNumber, plan.Url)
+	} else {
+		fmt.Printf("would install %s from %s\n", plan.Flavor, plan.Url)
+	}
+	os.Exit(0)
 }
 
-func PlatformSubdir() string {
-	os_ := runtime.GOOS
-	arch := runtime.GOARCH
-
-	platformMap := map[ArchSpec]string{
-		{"darwin", "amd64"}:  "osx-64",
-		{"darwin", "arm64"}:  "osx-arm64",
-		{"linux", "amd64"}:   "linux-64",
-		{"linux", "arm64"}:   "linux-aarch64",
-		{"linux", "ppc64le"}: "linux-ppc64le",
-		{"windows", "amd64"}: "win-64",
+// CreatePrefix uses a conda/mamba executable to create an environment at prefix containing
+// the given package specs.
+func CreatePrefix(executable string, prefix string, specs []string) error {
+	absPrefix, err := filepath.Abs(prefix)
+	if err != nil {
+		return err
 	}
+	args := append([]string{"create", "--yes", "--prefix", absPrefix}, specs...)
+	log.WithFields(log.Fields{
+		"executable": executable,
+		"prefix":     absPrefix,
+		"specs":      specs,
+	}).Info("creating environment")
 
-	return platformMap[ArchSpec{os_, arch}]
+	c := exec.Command(executable, args...)
+	// stdout is reserved for the resolved path, so the solver output goes to stderr
+	c.Stdout = os.Stderr
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return fmt.Errorf("could not create environment at %s: %w", absPrefix, err)
+	}
+	return nil
 }
 
 // Execute executes the root command.
 func Execute() error {
-	return rootCmd.Execute()
+	ctx, stop := interruptContext()
+	defer stop()
+	return rootCmd.ExecuteContext(ctx)
+}
+
+// interruptedExitCode is the exit status after SIGINT or SIGTERM, as shells report for
+// SIGINT.
+const interruptedExitCode = 130
+
+// interrupted is set once SIGINT or SIGTERM has been received.
+var interrupted int32
+
+// interruptContext returns a context canceled on SIGINT or SIGTERM, so that an install
+// interrupted with Ctrl-C stops downloading, releases its lock and removes its temporary
+// files instead of leaving them to block the next run.  A second signal exits at once.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			atomic.StoreInt32(&interrupted, 1)
+			log.WithField("signal", sig).Warn("interrupted, cleaning up")
+			cancel()
+		case <-ctx.Done():
+			return
+		}
+		<-signals
+		os.Exit(interruptedExitCode)
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
 }
 
 func er(msg interface{}) {
 	log.Error(msg)
+	if jsonOutput {
+		printJSONError(msg)
+	}
+	if atomic.LoadInt32(&interrupted) != 0 {
+		os.Exit(interruptedExitCode)
+	}
 	os.Exit(1)
 }
 
@@ -200,22 +584,154 @@ func evaluateFlagPair(cmd *cobra.Command, flag string) (bool, error) {
 	return cmd.Flags().GetBool(flag)
 }
 
+var flavorFlags = []string{"mamba", "micromamba", "conda", "conda-exe"}
+
+// onlyFlavor returns the flavor flag selected by --only or ENSURECONDA_ONLY, or "" when
+// neither is set.  Flavor flags given alongside it must not contradict it.
+func onlyFlavor(cmd *cobra.Command) (string, error) {
+	only, err := cmd.Flags().GetString("only")
+	if err != nil {
+		return "", err
+	}
+	source := "--only"
+	if only == "" {
+		only = os.Getenv("ENSURECONDA_ONLY")
+		source = "ENSURECONDA_ONLY"
+	}
+	if only == "" {
+		return "", nil
+	}
+	if only == "conda-standalone" || only == "conda_standalone" {
+		only = "conda-exe"
+	}
+
+	known := false
+	for _, flag := range flavorFlags {
+		if flag == only {
+			known = true
+			if cmd.Flag("no-" + flag).Changed {
+				return "", fmt.Errorf("%s=%s contradicts --no-%s", source, only, flag)
+			}
+		} else if cmd.Flag(flag).Changed {
+			return "", fmt.Errorf("%s=%s contradicts --%s", source, only, flag)
+		}
+	}
+	if !known {
+		return "", fmt.Errorf("%s: unknown flavor %q, expected one of %v", source, only, flavorFlags)
+	}
+	return only, nil
+}
+
 func init() {
 	rootCmd.PersistentFlags().Bool("mamba", true, "Search for mamba")
-	rootCmd.PersistentFlags().Bool("no-mamba", false, "")
+	rootCmd.PersistentFlags().Bool("no-mamba", false, "Don't search for mamba")
 
 	rootCmd.PersistentFlags().Bool("micromamba", true, "Search for micromamba, Can install")
-	rootCmd.PersistentFlags().Bool("no-micromamba", false, "")
+	rootCmd.PersistentFlags().Bool("no-micromamba", false, "Don't search for or install micromamba")
 
 	rootCmd.PersistentFlags().Bool("conda", true, "Search for conda")
-	rootCmd.PersistentFlags().Bool("no-conda", false, "")
+	rootCmd.PersistentFlags().Bool("no-conda", false, "Don't search for conda")
 
 	rootCmd.PersistentFlags().Bool("conda-exe", true, "Search for conda.exe/ conda standalong.  Can install")
-	rootCmd.PersistentFlags().Bool("no-conda-exe", false, "")
+	rootCmd.PersistentFlags().Bool("no-conda-exe", false, "Don't search for or install conda standalone")
+
+	rootCmd.PersistentFlags().String("only", "", "Only search for (and install) this flavor: mamba, micromamba, conda or conda-exe.  "+
+		"Defaults to ENSURECONDA_ONLY")
 
 	rootCmd.PersistentFlags().Bool("no-install", false, "Don't install stuff")
+	rootCmd.PersistentFlags().Bool("no-install-micromamba", false, "Search for micromamba but don't install it")
+	rootCmd.PersistentFlags().Bool("no-install-conda-exe", false, "Search for conda standalone but don't install it, e.g. where policy forbids downloading it")
+	rootCmd.PersistentFlags().StringSlice("conda-standalone-source", []string{ensureconda.SourceChannel},
+		"Where to fetch conda-standalone from, in order of preference: channel (the anaconda.org channel) and/or github (GitHub releases)")
+	rootCmd.PersistentFlags().String("conda-standalone-package", ensureconda.DefaultCondaStandalonePackage,
+		"Name conda-standalone is published under in its channel, e.g. for a mirror repackaging it")
+	rootCmd.PersistentFlags().String("conda-standalone-version", "", "Install this conda-standalone version instead of the newest, e.g. 23.11.0.  "+
+		"Pinned versions are kept side by side in the data directory")
+	rootCmd.PersistentFlags().Int("conda-standalone-min-build-number", 0, "Only install conda-standalone builds with at least this build number, "+
+		"e.g. a rebuild of the --conda-standalone-version that fixes a bug")
+	rootCmd.PersistentFlags().Bool("allow-onedir", false, "Allow installing the onedir builds of conda-standalone, which are skipped by default")
+	rootCmd.PersistentFlags().String("exclude-builds", "", "Never install conda-standalone builds whose build string matches this regular expression "+
+		"(default "+ensureconda.DefaultExcludeBuilds+" unless --allow-onedir)")
+	rootCmd.PersistentFlags().String("micromamba-channel", "", "Install micromamba as a conda package from this channel name or url, e.g. conda-forge, "+
+		"instead of from micromamba.snakepit.net")
+	rootCmd.PersistentFlags().StringSlice("micromamba-mirrors", nil, "Urls of the latest micromamba package to try in order before micromamba.snakepit.net, "+
+		"with {subdir} standing for the platform, e.g. https://mirror.example.com/micromamba/{subdir}/latest.  "+
+		"file:// urls and absolute paths of archives on disk are installed from there, even with --offline")
+	rootCmd.PersistentFlags().String("platform", "", "Install micromamba/conda-standalone for this conda subdir, e.g. linux-aarch64, instead of the host's.  "+
+		"Executables for another platform are downloaded to a subdirectory of the data directory without being run")
+	rootCmd.PersistentFlags().Int("probe-concurrency", ensureconda.DefaultProbeConcurrency, "How many PATH entries to check for executables at once, "+
+		"e.g. 1 to check them one by one")
+	rootCmd.PersistentFlags().Bool("no-path-search", false, "Only use executables in the data directory, never ones found on PATH")
+	rootCmd.PersistentFlags().String("data-dir-search", ensureconda.DataDirFirst, "Where to search the data directory for executables: "+
+		"first, preferring installed ones, last, preferring ones on PATH, or never")
+	rootCmd.PersistentFlags().String("mamba-version", "", "Versions of mamba/micromamba to accept, as pep440 specifiers, e.g. \">=1.5,<2\"; "+
+		"a bare version is a minimum (default >="+ensureconda.DefaultMinMambaVersion+")")
+	rootCmd.PersistentFlags().String("conda-version", "", "Versions of conda/conda-standalone to accept, as pep440 specifiers, e.g. \">=23.1,<24\"; "+
+		"a bare version is a minimum (default >="+ensureconda.DefaultMinCondaVersion+")")
+	rootCmd.PersistentFlags().Bool("prefer-system-conda", false, "Use a conda on PATH not matching --conda-version instead of installing conda-standalone")
+	rootCmd.PersistentFlags().Bool("prefer-system", false, "Use an existing executable of any enabled flavor whatever its version instead of installing one.  "+
+		"Installed executables must still match --mamba-version and --conda-version")
+	rootCmd.PersistentFlags().Bool("no-shim-filtering", false, "Search pyenv shim directories on PATH, which are skipped by default")
+	rootCmd.PersistentFlags().Bool("force-install", false, "Download micromamba/conda-standalone even if a usable executable was found, e.g. to replace a broken copy")
+	rootCmd.PersistentFlags().Bool("download-only", false, "Download micromamba/conda-standalone and print its path without searching PATH or running it, e.g. to warm a cache")
+	rootCmd.PersistentFlags().String("install-mode", "0755", "Permission bits of installed executables, and of the directories created for them, e.g. 0775 to share the data directory "+
+		"with a group of build agents")
+	rootCmd.PersistentFlags().Bool("self-test", false, "Check that an installed executable can run \"info --json\", removing it if not")
+	rootCmd.PersistentFlags().String("bin-layout", "", "Also make the resolved executable available as DIR/bin/conda, DIR/bin/mamba or DIR/bin/micromamba "+
+		"and print that path, so that DIR/bin can be put on PATH")
+	rootCmd.PersistentFlags().String("keep-archive", "", "Save downloaded package archives to this directory, "+
+		"or the data directory when no directory is given, e.g. to attach them to bug reports")
+	rootCmd.PersistentFlags().Lookup("keep-archive").NoOptDefVal = keepArchiveInDataDir
+	rootCmd.PersistentFlags().Bool("dry-run", false, "Report what would be installed instead of installing it")
+	rootCmd.PersistentFlags().String("data-dir", "", "Directory to install micromamba/conda-standalone to and search first, "+
+		"e.g. when the default isn't writable (default "+ensureconda.DefaultDataDir()+")")
+	rootCmd.PersistentFlags().String("lock-dir", "", "Directory for install lock files, e.g. on a local file system when the data directory is on a network mount")
+	rootCmd.PersistentFlags().String("socks-proxy", "", "Send all requests through this socks5://host:port proxy.  "+
+		"Defaults to a socks5 proxy in ALL_PROXY for requests not covered by HTTP_PROXY/HTTPS_PROXY")
+	rootCmd.PersistentFlags().StringArray("auth-header", nil, "Send this \"Name: value\" header to the hosts of the configured channels and micromamba mirrors, e.g. for a private mirror.  "+
+		"Can be repeated.  Defaults to ENSURECONDA_AUTH_HEADER")
+	rootCmd.PersistentFlags().String("token", "", "Send this token as an \"Authorization: Bearer\" header along with --auth-header.  "+
+		"Defaults to ENSURECONDA_TOKEN")
+	rootCmd.PersistentFlags().String("user-agent", "", "Send this User-Agent header with every request, e.g. one a mirror's firewall allows.  "+
+		"Defaults to ENSURECONDA_USER_AGENT, or else ensureconda and its version")
+	rootCmd.PersistentFlags().String("ca-bundle", "", "PEM file of extra root certificates to trust, e.g. for a TLS intercepting proxy.  "+
+		"Defaults to ENSURECONDA_CA_BUNDLE or SSL_CERT_FILE")
+	rootCmd.PersistentFlags().Bool("offline", false, "Never reach the network, only using executables already present (default from ENSURECONDA_OFFLINE)")
+	rootCmd.PersistentFlags().Bool("insecure", false, "Disable TLS certificate verification.  Downloads can then be tampered with; only use this for debugging")
+	rootCmd.PersistentFlags().Bool("verify-codesign", false, "On macOS, check the code signature of installed executables")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Give up resolving and installing after this long, exiting with status 124.  "+
+		"Zero means no limit")
+	rootCmd.PersistentFlags().Duration("lock-timeout", ensureconda.DefaultLockTimeout, "How long to wait for another ensureconda process to finish installing")
+	rootCmd.PersistentFlags().Int("file-lock-retries", 0, fmt.Sprintf("How many times to try locking an executable another process is writing "+
+		"(default from ENSURECONDA_FILE_LOCK_RETRIES, else %d)", ensureconda.DefaultFileLockRetries))
+	rootCmd.PersistentFlags().Duration("file-lock-max-delay", 0, fmt.Sprintf("Longest backoff between tries to lock an executable another process is writing "+
+		"(default from ENSURECONDA_FILE_LOCK_MAX_DELAY, else %s)", ensureconda.DefaultFileLockMaxDelay))
+
+	rootCmd.PersistentFlags().String("config", "", "YAML file of flag values, e.g. \"data-dir: /opt/ensureconda\", that flags given on the command line override "+
+		"(default .ensureconda.yaml, or ensureconda/config.yaml in the user config directory)")
+	rootCmd.PersistentFlags().Bool("print-data-dir", false, "Print the directory micromamba and conda-standalone are installed to, and exit")
+	rootCmd.PersistentFlags().Bool("print-platform-subdir", false, "Print the conda subdir of this platform, or unsupported, "+
+		"along with the GOOS and GOARCH it was derived from, and exit")
+
+	rootCmd.PersistentFlags().Bool("json", false, "Print the resolved executable, flavor and version as a JSON object, and failures as one with "+
+		"error, detail and kind (the flavor being installed) keys, e.g. for tools wrapping ensureconda")
+	rootCmd.PersistentFlags().Bool("null", false, "End the printed path with a NUL byte instead of a newline, e.g. for xargs -0")
+	rootCmd.PersistentFlags().String("shell-quote", "", "Quote the printed path for this shell, e.g. for eval with paths holding spaces.  "+
+		"One of sh, bash, zsh, fish, powershell or nushell")
+	rootCmd.PersistentFlags().String("print-activate", "", "Print shell code activating the resolved executable instead of its path, "+
+		"e.g. eval \"$(ensureconda --print-activate=bash)\".  One of bash, zsh, fish or powershell; with --prefix the environment is activated too")
+	rootCmd.PersistentFlags().String("prefix", "", "Create an environment at this prefix using the resolved executable, "+
+		"installing any package specs given as arguments, and print the prefix instead of the executable")
 
 	// TODO: implement logger + verbosity
 	rootCmd.PersistentFlags().IntP("verbosity", "v", 1, "verbosity level (0-3)")
+	rootCmd.PersistentFlags().String("log-file", "", "Also append log messages to this file, at --log-file-verbosity")
+	rootCmd.PersistentFlags().Int("log-file-verbosity", 3, "verbosity level (0-3) of --log-file")
+	rootCmd.PersistentFlags().String("log-format", "text", "Format of log messages: text or json")
+	rootCmd.PersistentFlags().Bool("no-color", false, "Never color log messages, which are otherwise colored when stderr is a terminal and NO_COLOR is unset")
+
+	if err := registerCompletions(); err != nil {
+		panic(err)
+	}
 
 }
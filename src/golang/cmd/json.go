@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/conda-incubator/ensureconda/src/golang/ensureconda"
+	"os"
+	"sync/atomic"
+)
+
+// jsonOutput is set by --json, printing results and failures to stdout as JSON objects
+// for wrapping tools to parse.
+var jsonOutput bool
+
+// jsonResult is printed for the resolved executable with --json.
+type jsonResult struct {
+	Executable string `json:"executable"`
+	Flavor     string `json:"flavor"`
+	Version    string `json:"version,omitempty"`
+	Prefix     string `json:"prefix,omitempty"`
+}
+
+// jsonPlan is printed for the install a dry run would have performed with --json.
+type jsonPlan struct {
+	Flavor      string `json:"flavor"`
+	Url         string `json:"url"`
+	Version     string `json:"version,omitempty"`
+	BuildNumber int32  `json:"build_number,omitempty"`
+}
+
+// jsonError is printed for failures with --json.  Error summarizes what failed, Detail is
+// the full message and Kind, when known, the flavor being installed.
+type jsonError struct {
+	Error  string `json:"error"`
+	Detail string `json:"detail,omitempty"`
+	Kind   string `json:"kind,omitempty"`
+}
+
+func printJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(data))
+}
+
+// printJSONError prints msg, an error or a usage message, as a jsonError.
+func printJSONError(msg interface{}) {
+	err, ok := msg.(error)
+	if !ok {
+		printJSON(jsonError{Error: "invalid arguments", Detail: fmt.Sprint(msg)})
+		return
+	}
+	out := jsonError{Error: errorSummary(err), Detail: err.Error()}
+	var installErr *ensureconda.InstallError
+	if errors.As(err, &installErr) {
+		out.Kind = string(installErr.Flavor)
+	}
+	printJSON(out)
+}
+
+// errorSummary names the kind of failure err is, e.g. "install failed".
+func errorSummary(err error) string {
+	var installErr *ensureconda.InstallError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case atomic.LoadInt32(&interrupted) != 0:
+		return "interrupted"
+	case errors.Is(err, ensureconda.ErrNotFound):
+		return "not found"
+	case errors.Is(err, ensureconda.ErrOffline):
+		return "offline"
+	case errors.As(err, &installErr):
+		return "install failed"
+	case errors.Is(err, os.ErrPermission):
+		return "permission denied"
+	}
+	return "failed"
+}
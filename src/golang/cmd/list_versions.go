@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/conda-incubator/ensureconda/src/golang/ensureconda"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var listVersionsCmd = &cobra.Command{
+	Use:   "list-versions",
+	Short: "List the builds of conda-standalone or micromamba that can be installed",
+	Long: `List the builds of conda-standalone, or of micromamba from --micromamba-channel, available for
+this platform or --platform from oldest to newest, with their build number, build string
+and upload time, e.g. to choose a --conda-standalone-version.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := applyConfigFile(cmd); err != nil {
+			er(err)
+		}
+		opts := installOptions(cmd)
+		flavorName, err := cmd.Flags().GetString("flavor")
+		if err != nil {
+			panic(err)
+		}
+		channel, err := cmd.Flags().GetString("channel")
+		if err != nil {
+			panic(err)
+		}
+		var flavor ensureconda.Flavor
+		switch flavorName {
+		case "conda-standalone", "conda_standalone", "conda-exe":
+			flavor = ensureconda.CondaStandalone
+			opts.CondaStandaloneChannel = channel
+		case "micromamba":
+			flavor = ensureconda.Micromamba
+			if channel != "" {
+				opts.MicromambaChannel = channel
+			}
+		default:
+			er(fmt.Errorf("--flavor: unknown flavor %q, expected conda-standalone or micromamba", flavorName))
+		}
+		setupLoggingFromFlags(cmd)
+		ctx, cancel, timeout := timeoutContext(cmd)
+		defer cancel()
+
+		pkgs, err := ensureconda.ListVersions(ctx, opts, flavor)
+		exitOnTimeout(ctx, err, timeout, nil)
+		if err != nil {
+			er(err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, pkg := range pkgs {
+			build, uploaded := pkg.Attrs.Build, "-"
+			if build == "" {
+				build = "-"
+			}
+			if t := pkg.Attrs.Time(); !t.IsZero() {
+				uploaded = t.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", pkg.Attrs.Version, pkg.Attrs.BuildNumber, build, uploaded)
+		}
+		w.Flush()
+	},
+}
+
+func init() {
+	listVersionsCmd.Flags().String("flavor", "conda-standalone", "Which builds to list: conda-standalone or micromamba")
+	listVersionsCmd.Flags().String("channel", "", "Channel name or url to list the builds of, "+
+		"instead of the conda-standalone channel or --micromamba-channel")
+	if err := listVersionsCmd.RegisterFlagCompletionFunc("flavor", completeWords("conda-standalone", "micromamba")); err != nil {
+		panic(err)
+	}
+	rootCmd.AddCommand(listVersionsCmd)
+}
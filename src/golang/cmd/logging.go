@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// verbosityLevel maps a --verbosity value to a log level.
+func verbosityLevel(verbosity int) log.Level {
+	switch verbosity {
+	case 3:
+		return log.TraceLevel
+	case 2:
+		return log.DebugLevel
+	case 1:
+		return log.InfoLevel
+	case 0:
+		return log.WarnLevel
+	default:
+		return log.InfoLevel
+	}
+}
+
+// writerHook writes entries up to a level to a writer, letting the console and a log file
+// be kept at different verbosities.
+type writerHook struct {
+	writer    io.Writer
+	level     log.Level
+	formatter log.Formatter
+}
+
+func (h *writerHook) Levels() []log.Level {
+	var levels []log.Level
+	for _, level := range log.AllLevels {
+		if level <= h.level {
+			levels = append(levels, level)
+		}
+	}
+	return levels
+}
+
+func (h *writerHook) Fire(entry *log.Entry) error {
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.writer.Write(line)
+	return err
+}
+
+// setupLoggingFromFlags sets up logging as configured by the flags of cmd.
+func setupLoggingFromFlags(cmd *cobra.Command) {
+	verbosity, err := cmd.Flags().GetInt("verbosity")
+	if err != nil {
+		panic(err)
+	}
+	logFile, err := cmd.Flags().GetString("log-file")
+	if err != nil {
+		panic(err)
+	}
+	logFileVerbosity, err := cmd.Flags().GetInt("log-file-verbosity")
+	if err != nil {
+		panic(err)
+	}
+	logFormat, err := cmd.Flags().GetString("log-format")
+	if err != nil {
+		panic(err)
+	}
+	noColor, err := cmd.Flags().GetBool("no-color")
+	if err != nil {
+		panic(err)
+	}
+	if err := setupLogging(logFormat, verbosityLevel(verbosity), logFile, verbosityLevel(logFileVerbosity), noColor); err != nil {
+		er(err)
+	}
+}
+
+// colorsDisabled reports whether colored log messages are turned off by --no-color or by
+// setting NO_COLOR, see https://no-color.org.  Otherwise logrus only colors them when
+// stderr is a terminal.
+func colorsDisabled(noColor bool) bool {
+	return noColor || os.Getenv("NO_COLOR") != ""
+}
+
+// setupLogging logs to stderr at consoleLevel and, if logFile is given, appends to it at
+// fileLevel.  format is either text or json; noColor turns off colored text.
+func setupLogging(format string, consoleLevel log.Level, logFile string, fileLevel log.Level, noColor bool) error {
+	var consoleFormatter, fileFormatter log.Formatter
+	switch format {
+	case "text":
+		consoleFormatter = &log.TextFormatter{DisableColors: colorsDisabled(noColor)}
+		fileFormatter = &log.TextFormatter{DisableColors: true}
+	case "json":
+		consoleFormatter = &log.JSONFormatter{}
+		fileFormatter = consoleFormatter
+	default:
+		return fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+	log.SetFormatter(consoleFormatter)
+
+	if logFile == "" {
+		log.SetLevel(consoleLevel)
+		return nil
+	}
+	f, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	level := consoleLevel
+	if fileLevel > level {
+		level = fileLevel
+	}
+	log.SetLevel(level)
+	log.SetOutput(ioutil.Discard)
+	log.AddHook(&writerHook{writer: os.Stderr, level: consoleLevel, formatter: consoleFormatter})
+	log.AddHook(&writerHook{writer: f, level: fileLevel, formatter: fileFormatter})
+	return nil
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/conda-incubator/ensureconda/src/golang/ensureconda"
+	"github.com/spf13/cobra"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var refreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Reinstall the newest micromamba and conda-standalone in the data directory",
+	Long: `Download the newest micromamba and conda-standalone over those already installed in the
+data directory, e.g. when rebuilding a shared image, and print their old and new versions.
+Executables that were never installed are left alone.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := applyConfigFile(cmd); err != nil {
+			er(err)
+		}
+		opts := installOptions(cmd)
+		setupLoggingFromFlags(cmd)
+		ctx, cancel, timeout := timeoutContext(cmd)
+		defer cancel()
+
+		refreshed, err := ensureconda.Refresh(ctx, opts)
+		for _, r := range refreshed {
+			fmt.Printf("%s %s -> %s (%s)\n", r.Flavor, ensureconda.VersionString(r.OldVersion), ensureconda.VersionString(r.NewVersion), r.Executable)
+		}
+		exitOnTimeout(ctx, err, timeout, nil)
+		if err != nil {
+			er(err)
+		}
+		if len(refreshed) == 0 {
+			log.Warn("nothing to refresh, neither micromamba nor conda-standalone is installed in the data directory")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(refreshCmd)
+}
@@ -0,0 +1,102 @@
+package ensureconda
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// executableArch inspects the header of an ELF, Mach-O or PE executable and returns the
+// GOARCH it was built for.  Universal Mach-O binaries yield every architecture they contain.
+// An empty result means the file format or architecture wasn't recognized.
+func executableArch(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if ef, err := elf.NewFile(f); err == nil {
+		switch ef.Machine {
+		case elf.EM_X86_64:
+			return []string{"amd64"}, nil
+		case elf.EM_386:
+			return []string{"386"}, nil
+		case elf.EM_AARCH64:
+			return []string{"arm64"}, nil
+		case elf.EM_ARM:
+			return []string{"arm"}, nil
+		case elf.EM_PPC64:
+			if ef.ByteOrder == binary.LittleEndian {
+				return []string{"ppc64le"}, nil
+			}
+			return []string{"ppc64"}, nil
+		case elf.EM_S390:
+			return []string{"s390x"}, nil
+		case elf.EM_RISCV:
+			return []string{"riscv64"}, nil
+		}
+		return []string{ef.Machine.String()}, nil
+	}
+	if mf, err := macho.NewFile(f); err == nil {
+		return []string{machoArch(mf.Cpu)}, nil
+	}
+	if ff, err := macho.NewFatFile(f); err == nil {
+		var arches []string
+		for _, arch := range ff.Arches {
+			arches = append(arches, machoArch(arch.Cpu))
+		}
+		return arches, nil
+	}
+	if pf, err := pe.NewFile(f); err == nil {
+		switch pf.Machine {
+		case pe.IMAGE_FILE_MACHINE_AMD64:
+			return []string{"amd64"}, nil
+		case pe.IMAGE_FILE_MACHINE_I386:
+			return []string{"386"}, nil
+		case pe.IMAGE_FILE_MACHINE_ARM64:
+			return []string{"arm64"}, nil
+		}
+		return []string{fmt.Sprintf("pe machine %#x", pf.Machine)}, nil
+	}
+	return nil, nil
+}
+
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "amd64"
+	case macho.Cpu386:
+		return "386"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.CpuArm:
+		return "arm"
+	case macho.CpuPpc64:
+		return "ppc64"
+	}
+	return cpu.String()
+}
+
+// checkExecutableArch returns an error when the executable at path was built for a
+// different architecture than goarch.  Files in unrecognized formats are accepted.
+func (e *ensurer) checkExecutableArch(path string, goarch string) error {
+	arches, err := executableArch(path)
+	if err != nil {
+		return err
+	}
+	if len(arches) == 0 {
+		e.log.WithField("executable", path).Debug("could not determine executable architecture")
+		return nil
+	}
+	for _, arch := range arches {
+		if arch == goarch {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is built for %v but %s was expected; check the platform subdir and mirror configuration",
+		path, arches, goarch)
+}
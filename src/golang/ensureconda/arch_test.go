@@ -0,0 +1,88 @@
+package ensureconda
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeElfHeader writes a minimal 64-bit little endian ELF header for machine.
+func writeElfHeader(t *testing.T, path string, machine uint16) {
+	header := make([]byte, 64)
+	copy(header, []byte{0x7f, 'E', 'L', 'F', 2, 1, 1})
+	binary.LittleEndian.PutUint16(header[16:], 2) // ET_EXEC
+	binary.LittleEndian.PutUint16(header[18:], machine)
+	binary.LittleEndian.PutUint32(header[20:], 1) // EV_CURRENT
+	binary.LittleEndian.PutUint16(header[52:], 64)
+	if err := ioutil.WriteFile(path, header, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckExecutableArch(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.checkExecutableArch(self, runtime.GOARCH); err != nil {
+		t.Errorf("checkExecutableArch() on the test binary error = %v", err)
+	}
+
+	aarch64 := filepath.Join(opts.DataDir, "aarch64")
+	writeElfHeader(t, aarch64, 183) // EM_AARCH64
+	if err := e.checkExecutableArch(aarch64, "arm64"); err != nil {
+		t.Errorf("checkExecutableArch() error = %v", err)
+	}
+	if err := e.checkExecutableArch(aarch64, "amd64"); err == nil {
+		t.Errorf("checkExecutableArch() expected a mismatch error for an arm64 binary on amd64")
+	}
+
+	script := filepath.Join(opts.DataDir, "script")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\necho conda 4.9.0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.checkExecutableArch(script, runtime.GOARCH); err != nil {
+		t.Errorf("checkExecutableArch() should accept unrecognized formats, error = %v", err)
+	}
+}
+
+func TestWriteFileKeepsExecutableOfWrongArch(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+	target := e.targetExeFilename("micromamba")
+	if err := e.writeFile(context.Background(), target, strings.NewReader("#!/bin/sh\necho 1.5.8\n"), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	// a download for another architecture leaves the installed executable alone
+	other := filepath.Join(opts.DataDir, "other")
+	var machine uint16 = 183 // EM_AARCH64
+	if e.platform.arch == "arm64" {
+		machine = 62 // EM_X86_64
+	}
+	writeElfHeader(t, other, machine)
+	f, err := os.Open(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := e.writeFile(context.Background(), target, f, -1); err == nil {
+		t.Errorf("writeFile() expected a mismatch error for an executable built for another architecture")
+	}
+	if content, _ := ioutil.ReadFile(target); string(content) != "#!/bin/sh\necho 1.5.8\n" {
+		t.Errorf("writeFile() replaced the installed executable with %q", content)
+	}
+	if _, err := os.Stat(target + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("writeFile() left %s.tmp behind", target)
+	}
+}
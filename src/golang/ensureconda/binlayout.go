@@ -0,0 +1,85 @@
+package ensureconda
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+// binLayoutName returns the name the executable of flavor goes by in a bin directory.
+func binLayoutName(flavor Flavor) string {
+	if flavor == CondaStandalone {
+		return "conda"
+	}
+	return string(flavor)
+}
+
+// withBinLayout makes the executable of result available as bin/conda, bin/mamba or
+// bin/micromamba under Options.BinLayoutDir and returns result with that as its executable.
+// A symlink is made, or else a hard link or a copy where symlinks aren't allowed, as on
+// Windows without developer mode.
+func (e *ensurer) withBinLayout(ctx context.Context, result Result) (Result, error) {
+	if e.opts.BinLayoutDir == "" {
+		return result, nil
+	}
+	source, err := filepath.Abs(result.Executable)
+	if err != nil {
+		return Result{}, err
+	}
+	binDir := filepath.Join(e.opts.BinLayoutDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		return Result{}, err
+	}
+	name := binLayoutName(result.Flavor)
+	if e.platform.os == "windows" {
+		name += filepath.Ext(source)
+	}
+	link := filepath.Join(binDir, name)
+
+	if sameFile(source, link) {
+		result.Executable = link
+		return result, nil
+	}
+	tmpFileName := link + ".tmp"
+	_ = os.Remove(tmpFileName)
+	err = os.Symlink(source, tmpFileName)
+	if err != nil {
+		err = os.Link(source, tmpFileName)
+	}
+	if err == nil {
+		if err = replaceFile(tmpFileName, link); err != nil {
+			_ = os.Remove(tmpFileName)
+		}
+	} else {
+		e.log.WithField("executable", source).WithError(err).Debug("could not link executable, copying it")
+		err = e.copyExecutable(ctx, source, link)
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	e.log.WithField("executable", source).WithField("link", link).Debug("made executable available in bin layout")
+	result.Executable = link
+	return result, nil
+}
+
+// sameFile reports whether a and b exist and are the same file.
+func sameFile(a, b string) bool {
+	stA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	stB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(stA, stB)
+}
+
+func (e *ensurer) copyExecutable(ctx context.Context, source string, target string) error {
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return e.writeFile(ctx, target, f, -1)
+}
@@ -0,0 +1,61 @@
+package ensureconda
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBinLayout(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	exe := writeFakeConda(t, opts.DataDir, "conda_standalone", "24.3.0")
+	opts.CondaStandalone = true
+	opts.NoInstall = true
+	opts.NoPathSearch = true
+	opts.BinLayoutDir = filepath.Join(opts.DataDir, "layout")
+	want := filepath.Join(opts.BinLayoutDir, "bin", "conda")
+
+	// resolving again replaces the link made the first time
+	for i := 0; i < 2; i++ {
+		got, err := Resolve(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		if got.Executable != want || got.Flavor != CondaStandalone {
+			t.Errorf("Resolve() got = %+v, want %s", got, want)
+		}
+		if !sameFile(want, exe) {
+			t.Errorf("Resolve() made %s, want it to be %s", want, exe)
+		}
+		out, err := exec.Command(want, "--version").Output()
+		if err != nil || strings.TrimSpace(string(out)) != "conda 24.3.0" {
+			t.Errorf("%s --version = %q, %v, want conda 24.3.0", want, out, err)
+		}
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(opts.BinLayoutDir, "bin", "*.tmp")); len(leftovers) > 0 {
+		t.Errorf("Resolve() left temporary files behind: %v", leftovers)
+	}
+}
+
+func TestBinLayoutName(t *testing.T) {
+	tests := []struct {
+		flavor Flavor
+		want   string
+	}{
+		{Mamba, "mamba"},
+		{Micromamba, "micromamba"},
+		{Conda, "conda"},
+		{CondaStandalone, "conda"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.flavor), func(t *testing.T) {
+			if got := binLayoutName(tt.flavor); got != tt.want {
+				t.Errorf("binLayoutName() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
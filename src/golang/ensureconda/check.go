@@ -0,0 +1,101 @@
+package ensureconda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-version"
+)
+
+// FlavorCheck is the health of the executable of one flavor, as reported by Check.
+type FlavorCheck struct {
+	Flavor Flavor
+	// Executable is empty when none was found.
+	Executable string
+	// Version is nil when it couldn't be determined.
+	Version *version.Version
+	// Err explains why the executable is not usable, or is nil when it passed.
+	Err error
+}
+
+// errNotInstalled is the FlavorCheck.Err of flavors without any executable.
+var errNotInstalled = errors.New("not found")
+
+// Check finds the executable of each enabled flavor in the data directory and on PATH, as
+// Resolve does but never installing anything, and checks its version and that it can run a
+// command.  The error is non-nil when the executable Resolve would pick fails its check, or
+// when there is none.
+func Check(ctx context.Context, opts Options) ([]FlavorCheck, error) {
+	e := newEnsurer(opts)
+	if e.versionSpecErr != nil {
+		return nil, e.versionSpecErr
+	}
+	if err := checkDataDirSearch(opts.DataDirSearch); err != nil {
+		return nil, err
+	}
+	condaStandaloneCheck := e.flavorCheck(CondaStandalone)
+	pinnedVersion, err := e.pinnedCondaStandaloneVersion()
+	if err != nil {
+		return nil, err
+	}
+	if pinnedVersion != nil {
+		condaStandaloneCheck = e.executableHasVersion(pinnedVersion, "conda")
+	}
+	flavors := []struct {
+		enabled bool
+		flavor  Flavor
+		exeName string
+		check   versionCheck
+	}{
+		{opts.Mamba, Mamba, "mamba", e.flavorCheck(Mamba)},
+		{opts.Micromamba, Micromamba, "micromamba", e.flavorCheck(Micromamba)},
+		{opts.Conda, Conda, "conda", e.flavorCheck(Conda)},
+		{opts.CondaStandalone, CondaStandalone, e.condaStandaloneExeName(), condaStandaloneCheck},
+	}
+
+	var checks []FlavorCheck
+	picked := -1
+	for _, f := range flavors {
+		if !f.enabled {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return checks, err
+		}
+		check := FlavorCheck{Flavor: f.flavor}
+		executable, exeVersion, err := e.resolveExecutable(ctx, f.exeName, e.dataDir, f.check)
+		if executable == "" {
+			check.Err = errNotInstalled
+			if rejected := rejectedExecutables(err); len(rejected) > 0 {
+				check.Executable = rejected[0].Executable
+				check.Version = rejected[0].Version
+				check.Err = fmt.Errorf("version %s is not acceptable", VersionString(check.Version))
+			}
+		} else {
+			check.Executable = executable
+			check.Version = exeVersion
+			check.Err = e.runSelfTest(ctx, executable)
+		}
+		if picked < 0 && executable != "" {
+			picked = len(checks)
+		}
+		checks = append(checks, check)
+	}
+
+	if picked < 0 {
+		return checks, ErrNotFound
+	}
+	if check := checks[picked]; check.Err != nil {
+		return checks, fmt.Errorf("%s at %s is broken: %w", check.Flavor, check.Executable, check.Err)
+	}
+	return checks, nil
+}
+
+// VersionString formats a version that may not be known, such as that of an executable whose
+// --version output couldn't be parsed, as "unknown".
+func VersionString(v *version.Version) string {
+	if v == nil {
+		return "unknown"
+	}
+	return v.String()
+}
@@ -0,0 +1,58 @@
+package ensureconda
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	healthy := "#!/bin/sh\nif [ \"$1\" = info ]; then echo '{}'; else echo conda 24.3.0; fi\n"
+	broken := "#!/bin/sh\nif [ \"$1\" = info ]; then echo boom >&2; exit 1; else echo conda 24.3.0; fi\n"
+	tooOld := "#!/bin/sh\nif [ \"$1\" = info ]; then echo '{}'; else echo conda 4.1.0; fi\n"
+	tests := []struct {
+		name    string
+		script  string
+		wantErr bool
+		wantNot error
+	}{
+		{"healthy", healthy, false, nil},
+		{"broken", broken, true, nil},
+		{"too old", tooOld, true, ErrNotFound},
+		{"missing", "", true, ErrNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			opts.Conda = true
+			opts.NoPathSearch = true
+			if tt.script != "" {
+				if err := ioutil.WriteFile(filepath.Join(opts.DataDir, "conda"), []byte(tt.script), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			checks, err := Check(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantNot != nil && !errors.Is(err, tt.wantNot) {
+				t.Errorf("Check() error = %v, want %v", err, tt.wantNot)
+			}
+			if len(checks) != 1 || checks[0].Flavor != Conda {
+				t.Fatalf("Check() got = %v, want one check of conda", checks)
+			}
+			if (checks[0].Err != nil) != tt.wantErr {
+				t.Errorf("Check() got Err = %v, wantErr %v", checks[0].Err, tt.wantErr)
+			}
+		})
+	}
+}
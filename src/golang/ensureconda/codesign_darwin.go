@@ -0,0 +1,19 @@
+package ensureconda
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// verifyCodesign checks the code signature of an executable, as Apple Silicon kills
+// unsigned or badly signed binaries with SIGKILL the moment they run.
+func (e *ensurer) verifyCodesign(path string) error {
+	out, err := exec.Command("codesign", "--verify", "--verbose", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("codesign verification of %s failed: %s; macOS will likely refuse to run it",
+			path, strings.TrimSpace(string(out)))
+	}
+	e.log.WithField("executable", path).Debug("code signature verified")
+	return nil
+}
@@ -0,0 +1,46 @@
+package ensureconda
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyCodesign(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+
+	if err := e.verifyCodesign("/bin/ls"); err != nil {
+		t.Errorf("verifyCodesign() error = %v for a system binary", err)
+	}
+
+	unsigned := filepath.Join(opts.DataDir, "micromamba")
+	if err := ioutil.WriteFile(unsigned, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.verifyCodesign(unsigned); err == nil {
+		t.Errorf("verifyCodesign() expected an error for an unsigned executable")
+	}
+}
+
+func TestPrepareExecutableForeignPlatform(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.Platform = "linux-64"
+	opts.VerifyCodesign = true
+	e := newEnsurer(opts)
+
+	// executables staged for another platform aren't checked against macOS' rules
+	unsigned := filepath.Join(opts.DataDir, "micromamba")
+	if err := ioutil.WriteFile(unsigned, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.prepareExecutable(unsigned); err != nil {
+		t.Errorf("prepareExecutable() error = %v for an executable of another platform", err)
+	}
+	if _, err := os.Stat(unsigned); err != nil {
+		t.Errorf("prepareExecutable() removed the executable of another platform: %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !darwin
+// +build !darwin
+
+package ensureconda
+
+// verifyCodesign is a no-op as code signatures are only enforced on macOS.
+func (e *ensurer) verifyCodesign(path string) error {
+	return nil
+}
@@ -0,0 +1,74 @@
+package ensureconda
+
+import (
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// condarcPaths returns the user's conda configuration files in order of precedence.
+func condarcPaths() []string {
+	var paths []string
+	if condarc := os.Getenv("CONDARC"); condarc != "" {
+		paths = append(paths, condarc)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths,
+			filepath.Join(home, ".condarc"),
+			filepath.Join(home, ".conda", ".condarc"),
+			filepath.Join(home, ".config", "conda", "condarc"),
+		)
+	}
+	return paths
+}
+
+// condarcChannels returns the channels configured in the user's .condarc that can be
+// queried for packages on anaconda.org, in order of precedence.  defaults is skipped, as is
+// any channel url not on conda.anaconda.org, since mirrors rarely serve the package api.
+func (e *ensurer) condarcChannels() []string {
+	var channels []string
+	seen := map[string]bool{}
+	for _, path := range condarcPaths() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var config struct {
+			Channels []string `yaml:"channels"`
+		}
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			e.log.WithField("condarc", path).WithError(err).Debug("ignoring unreadable condarc")
+			continue
+		}
+		for _, channel := range config.Channels {
+			if name := anacondaChannelName(channel); name != "" && !seen[name] {
+				e.log.WithField("condarc", path).WithField("channel", name).Debug("found channel in condarc")
+				seen[name] = true
+				channels = append(channels, name)
+			}
+		}
+	}
+	return channels
+}
+
+// anacondaChannelName turns a channel from .condarc, e.g. conda-forge or
+// https://conda.anaconda.org/conda-forge, into an anaconda.org channel name.
+func anacondaChannelName(channel string) string {
+	if channel == "defaults" {
+		return ""
+	}
+	if isChannelUrl(channel) {
+		u, err := url.Parse(channel)
+		if err != nil || u.Hostname() != "conda.anaconda.org" {
+			return ""
+		}
+		channel = strings.Trim(u.Path, "/")
+	}
+	if checkChannelName(channel) != nil {
+		return ""
+	}
+	return channel
+}
@@ -0,0 +1,147 @@
+package ensureconda
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// isolateCondarc points the home directory at an empty directory so that the user's own
+// .condarc doesn't leak into tests, returning that directory and a function restoring it.
+func isolateCondarc(t *testing.T) (string, func()) {
+	home, err := ioutil.TempDir("", "ensureconda-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := []string{"HOME", "USERPROFILE", "CONDARC"}
+	saved := map[string]string{}
+	for _, v := range vars {
+		saved[v] = os.Getenv(v)
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("USERPROFILE", home)
+	os.Unsetenv("CONDARC")
+	return home, func() {
+		for _, v := range vars {
+			os.Setenv(v, saved[v])
+		}
+		os.RemoveAll(home)
+	}
+}
+
+func TestAnacondaChannelName(t *testing.T) {
+	tests := []struct {
+		channel string
+		want    string
+	}{
+		{"conda-forge", "conda-forge"},
+		{"https://conda.anaconda.org/conda-forge", "conda-forge"},
+		{"https://conda.anaconda.org/conda-forge/", "conda-forge"},
+		{"defaults", ""},
+		{"https://artifactory.example.com/api/conda/conda", ""},
+		{"https://conda.anaconda.org/conda-forge/label/dev", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			if got := anacondaChannelName(tt.channel); got != tt.want {
+				t.Errorf("anacondaChannelName() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCondaStandaloneChannelsFromCondarc(t *testing.T) {
+	home, restore := isolateCondarc(t)
+	defer restore()
+	defer os.Unsetenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+
+	write := func(path string, content string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(home, ".condarc"), "channels:\n  - https://mirror.example.com/conda\n  - defaults\n")
+	write(filepath.Join(home, ".config", "conda", "condarc"), "channels:\n  - https://conda.anaconda.org/conda-forge\n")
+	explicit := filepath.Join(home, "explicit.condarc")
+	write(explicit, "channels: [my.org, conda-forge]\n")
+
+	tests := []struct {
+		name    string
+		condarc string
+		env     string
+		want    []string
+	}{
+		{"user condarc", "", "", []string{"conda-forge", "anaconda"}},
+		{"CONDARC", explicit, "", []string{"my.org", "conda-forge", "anaconda"}},
+		{"env var wins", explicit, "bioconda", []string{"bioconda"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("CONDARC", tt.condarc)
+			os.Setenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL", tt.env)
+			got, err := newEnsurer(Options{}).condaStandaloneChannels()
+			if err != nil {
+				t.Fatalf("condaStandaloneChannels() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("condaStandaloneChannels() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCondarcChannelFallback(t *testing.T) {
+	home, restore := isolateCondarc(t)
+	defer restore()
+	defer os.Setenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL", os.Getenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL"))
+	os.Unsetenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		listing := `[{"attrs": {"subdir": %q, "version": %q}, "download_url": "/download/conda-standalone.conda"}]`
+		switch r.URL.Path {
+		case "/package/empty/conda-standalone/files":
+			fmt.Fprint(w, `[]`)
+		case "/package/my.org/conda-standalone/files":
+			fmt.Fprintf(w, listing, PlatformSubdir(), "23.1.0")
+		case "/package/anaconda/conda-standalone/files":
+			fmt.Fprintf(w, listing, PlatformSubdir(), "24.3.0")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(url string) { anacondaApiUrl = url }(anacondaApiUrl)
+	anacondaApiUrl = server.URL + "/package"
+
+	tests := []struct {
+		name     string
+		channels string
+		want     string
+	}{
+		{"channels without conda-standalone", "[pytorch, empty]", "24.3.0"},
+		{"channel with conda-standalone", "[pytorch, my.org]", "23.1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(filepath.Join(home, ".condarc"), []byte("channels: "+tt.channels+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := newEnsurer(Options{}).chooseCondaStandalone(context.Background())
+			if err != nil {
+				t.Fatalf("chooseCondaStandalone() error = %v", err)
+			}
+			if got.Attrs.Version != tt.want {
+				t.Errorf("chooseCondaStandalone() got version %v, want %v", got.Attrs.Version, tt.want)
+			}
+		})
+	}
+}
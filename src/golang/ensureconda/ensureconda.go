@@ -0,0 +1,761 @@
+// Package ensureconda finds a conda/mamba executable, installing micromamba or
+// conda-standalone when nothing suitable is present.
+package ensureconda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Wessie/appdirs"
+	"github.com/hashicorp/go-version"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultMinMambaVersion = "0.7.3"
+const DefaultMinCondaVersion = "4.8.2"
+
+// DefaultCondaStandalonePackage is the name of the conda-standalone package.
+const DefaultCondaStandalonePackage = "conda-standalone"
+
+// DefaultProbeConcurrency is how many PATH entries are checked at once by default.
+const DefaultProbeConcurrency = 4
+
+// DefaultExcludeBuilds matches the onedir builds of conda-standalone, which crash on
+// startup (conda/conda-standalone#182).  Their build strings aren't named consistently,
+// e.g. h1234567_onedir_0 and onedir_h1234567_0, so any mention of onedir is matched.
+const DefaultExcludeBuilds = `(?i)onedir`
+
+// ErrNotFound is returned by Resolve when no suitable executable could be found or installed.
+var ErrNotFound = errors.New("could not find or install a suitable conda executable")
+
+// ErrOffline is returned instead of reaching the network in offline mode.
+var ErrOffline = errors.New("network access is disabled by --offline or ENSURECONDA_OFFLINE")
+
+// InstallError is returned by Resolve when installing an executable of Flavor, or planning
+// to, fails.  Its message is that of Err.
+type InstallError struct {
+	Flavor Flavor
+	Err    error
+}
+
+func (err *InstallError) Error() string {
+	return err.Err.Error()
+}
+
+func (err *InstallError) Unwrap() error {
+	return err.Err
+}
+
+// Options controls which executables Resolve considers and how it installs them.
+type Options struct {
+	// Mamba, Micromamba, Conda and CondaStandalone select which flavors are searched for,
+	// in that order of preference.
+	Mamba           bool
+	Micromamba      bool
+	Conda           bool
+	CondaStandalone bool
+
+	// NoInstall prevents installing micromamba or conda-standalone when nothing was found.
+	NoInstall bool
+
+	// NoInstallMicromamba and NoInstallCondaStandalone prevent installing just that flavor,
+	// which is still searched for, e.g. when policy forbids downloading conda-standalone.
+	NoInstallMicromamba      bool
+	NoInstallCondaStandalone bool
+
+	// DryRun reports what would be installed in Result.Planned instead of installing it.
+	DryRun bool
+
+	// SelfTest runs "info --json" with a freshly installed executable and fails the install
+	// if it errors, as a working --version doesn't mean commands can be run.
+	SelfTest bool
+
+	// DownloadOnly installs micromamba or conda-standalone without searching for existing
+	// executables or running the installed one to check it, e.g. to warm a cache in a
+	// container lacking the libraries it needs.  SelfTest is skipped as well.
+	DownloadOnly bool
+
+	// ForceInstall skips searching for preexisting executables, including ones installed
+	// earlier, and always downloads the newest micromamba or conda-standalone.
+	ForceInstall bool
+
+	// MinMambaVersion and MinCondaVersion default to DefaultMinMambaVersion and
+	// DefaultMinCondaVersion when nil.
+	MinMambaVersion *version.Version
+	MinCondaVersion *version.Version
+
+	// MambaVersionSpec and CondaVersionSpec replace MinMambaVersion and MinCondaVersion
+	// with pep440-style specifiers when set, e.g. ">=23.1,<24" to avoid a known-bad release.
+	// A bare version is a minimum.
+	MambaVersionSpec string
+	CondaVersionSpec string
+
+	// ProbeConcurrency is how many PATH entries are checked for an executable at once, which
+	// speeds up searching PATHs with slow network mounts.  The first suitable executable in
+	// PATH order still wins.  Defaults to DefaultProbeConcurrency; 1 checks them one by one.
+	ProbeConcurrency int
+
+	// NoPathSearch only searches the data directory for executables, ignoring PATH, so that
+	// nothing installed on the host is picked up.
+	NoPathSearch bool
+
+	// DataDirSearch is where the data directory is searched for executables relative to
+	// PATH: DataDirFirst, the default, prefers executables ensureconda installed,
+	// DataDirLast prefers ones on PATH and DataDirNever only searches PATH.  Installing still
+	// uses the data directory.
+	DataDirSearch string
+
+	// PreferSystemConda uses a conda found on PATH not meeting MinCondaVersion or
+	// CondaVersionSpec instead of installing conda-standalone.  Either way,
+	// Result.RejectedConda lists such condas.
+	PreferSystemConda bool
+
+	// PreferSystem uses an existing executable of any enabled flavor, whatever its version,
+	// instead of installing one when none meets the version requirements.  Executables
+	// installed by ensureconda must still meet them.
+	PreferSystem bool
+
+	// NoShimFiltering keeps pyenv shim directories on PATH when searching for executables.
+	// They are skipped by default as their executables only work inside pyenv environments.
+	NoShimFiltering bool
+
+	// DataDir is where executables are installed to and searched for first.  Defaults to
+	// DefaultDataDir(), or a subdirectory of it named after Platform when that is foreign.
+	DataDir string
+
+	// Platform is the conda subdir, e.g. linux-aarch64, that micromamba and conda-standalone
+	// are installed for.  Defaults to PlatformSubdir().  Another platform implies
+	// DownloadOnly.
+	Platform string
+
+	// LockDir holds the lock files guarding installs.  Defaults to a subdirectory of DataDir;
+	// pointing it at a local file system helps when DataDir is on a network mount.
+	LockDir string
+
+	// LockTimeout bounds how long to wait for another process installing the same
+	// executable.  Defaults to DefaultLockTimeout.
+	LockTimeout time.Duration
+
+	// FileLockRetries bounds how many times writing an executable tries to take its file
+	// lock while another process holds it, backing off up to FileLockMaxDelay between
+	// tries.  They default to ENSURECONDA_FILE_LOCK_RETRIES and
+	// ENSURECONDA_FILE_LOCK_MAX_DELAY, else DefaultFileLockRetries and
+	// DefaultFileLockMaxDelay.
+	FileLockRetries  int
+	FileLockMaxDelay time.Duration
+
+	// CondaStandaloneChannel is the channel name or channel url conda-standalone is
+	// installed from.  Defaults to $ENSURECONDA_CONDA_STANDALONE_CHANNEL, or else the first
+	// anaconda.org channel in the user's .condarc listing conda-standalone, then anaconda.
+	CondaStandaloneChannel string
+
+	// CondaStandalonePackage is the name conda-standalone is published under in its
+	// channel, for mirrors repackaging it under an internal name.  Defaults to
+	// DefaultCondaStandalonePackage.
+	CondaStandalonePackage string
+
+	// CondaStandaloneVersion pins the conda-standalone version to install instead of the
+	// newest, e.g. 23.11.0.  Pinned versions are installed side by side as
+	// conda_standalone-<version> in the data directory, so that projects pinning different
+	// versions don't replace each other's.
+	CondaStandaloneVersion string
+
+	// CondaStandaloneMinBuildNumber skips conda-standalone packages with a lower build
+	// number, e.g. to get a rebuild of the pinned CondaStandaloneVersion that fixes a bug.
+	// GitHub release assets have no build number and only satisfy 0.
+	CondaStandaloneMinBuildNumber int
+
+	// CondaStandaloneSources lists where conda-standalone is fetched from, SourceChannel
+	// and/or SourceGitHub, in order of preference; later sources are only used when the
+	// earlier ones fail.  Defaults to SourceChannel.
+	CondaStandaloneSources []string
+
+	// ExcludeBuilds is a regular expression matched against the build strings of
+	// conda-standalone packages; matching builds are never installed.  Defaults to
+	// DefaultExcludeBuilds unless AllowOnedir is set.
+	ExcludeBuilds string
+	AllowOnedir   bool
+
+	// MicromambaChannel is the channel name or channel url micromamba is installed from as a
+	// conda package, e.g. conda-forge.  By default the latest micromamba is installed from
+	// micromamba.snakepit.net.
+	MicromambaChannel string
+
+	// MicromambaMirrors are urls of the latest micromamba package tried in order before
+	// micromamba.snakepit.net, e.g. a mirror nearer by.  {subdir} in them is replaced with the
+	// conda subdir, e.g. https://mirror.example.com/micromamba/{subdir}/latest.  They are not
+	// used with MicromambaChannel.  file:// urls and absolute paths of archives staged on disk
+	// are read from there, and are the only mirrors used with Offline.
+	MicromambaMirrors []string
+
+	// SocksProxy is a socks5://host:port proxy all requests are sent through.  When empty,
+	// the standard proxy environment variables are honored, with ALL_PROXY used for SOCKS5.
+	SocksProxy string
+
+	// CABundle is a PEM file of root certificates trusted in addition to the system ones,
+	// e.g. for a TLS intercepting proxy.  Defaults to $ENSURECONDA_CA_BUNDLE or $SSL_CERT_FILE.
+	CABundle string
+
+	// Insecure disables TLS certificate verification.  Only meant for debugging.
+	Insecure bool
+
+	// Offline never reaches the network: only existing executables are used, and installs
+	// fail with ErrOffline.  It is also turned on by setting ENSURECONDA_OFFLINE, e.g. to 1,
+	// for tools running ensureconda without passing flags.
+	Offline bool
+
+	// VerifyCodesign checks the code signature of installed executables on macOS, reporting
+	// a broken signature instead of having the executable killed when it is first run.
+	VerifyCodesign bool
+
+	// InstallMode is the permission bits of installed executables, e.g. 0775 for a cache
+	// shared by a group of build agents.  It must include the owner's execute bit and
+	// defaults to 0755.  The data and lock directories ensureconda creates are opened up to
+	// the same group and others.
+	InstallMode os.FileMode
+
+	// BinLayoutDir, when set, is where the resolved executable is also made available as
+	// bin/conda, bin/mamba or bin/micromamba, so that its bin directory can be put on PATH
+	// by tools expecting a conda-like layout.  Result.Executable is then that path.
+	BinLayoutDir string
+
+	// KeepArchiveDir, when set, is where downloaded package archives are saved for
+	// inspection instead of being discarded after unpacking.
+	KeepArchiveDir string
+
+	// AuthHeaders are "Name: value" headers sent with requests to the hosts of the configured
+	// channels and micromamba mirrors, e.g. for a private mirror.  Defaults to
+	// $ENSURECONDA_AUTH_HEADER.
+	AuthHeaders []string
+
+	// Token is sent as an "Authorization: Bearer" header along with AuthHeaders.  Defaults to
+	// $ENSURECONDA_TOKEN.
+	Token string
+
+	// UserAgent is the User-Agent header of every request, e.g. one a mirror's firewall
+	// lets through.  Defaults to $ENSURECONDA_USER_AGENT, or else ensureconda and its version.
+	UserAgent string
+
+	// Logger receives progress and debug messages.  Nothing is logged when nil.
+	Logger log.FieldLogger
+}
+
+// Sources conda-standalone can be fetched from.
+const (
+	// SourceChannel is the anaconda.org compatible channel of Options.CondaStandaloneChannel.
+	SourceChannel = "channel"
+	// SourceGitHub is the release assets of the conda/conda-standalone GitHub repository.
+	SourceGitHub = "github"
+)
+
+// Where the data directory is searched for executables; see Options.DataDirSearch.
+const (
+	DataDirFirst = "first"
+	DataDirLast  = "last"
+	DataDirNever = "never"
+)
+
+// Flavor identifies a kind of conda executable.
+type Flavor string
+
+const (
+	Mamba           Flavor = "mamba"
+	Micromamba      Flavor = "micromamba"
+	Conda           Flavor = "conda"
+	CondaStandalone Flavor = "conda_standalone"
+)
+
+// Result describes the executable found by Resolve.
+type Result struct {
+	Executable string
+	Flavor     Flavor
+	Version    *version.Version
+
+	// Planned describes the install that would have been performed in a dry run.
+	// Executable is empty when it is set.
+	Planned *PlannedInstall
+
+	// RejectedConda lists the conda executables found on PATH but passed over, explaining
+	// why conda-standalone was installed despite a conda being present.
+	RejectedConda []RejectedExecutable
+}
+
+// PlannedInstall describes an install skipped because of Options.DryRun.
+type PlannedInstall struct {
+	Flavor Flavor
+	Url    string
+	// Version and BuildNumber are only known upfront for packages installed from a channel.
+	Version     string
+	BuildNumber int32
+}
+
+// DefaultDataDir returns the per-user directory executables are installed to.  On Linux
+// that is ensure-conda under $XDG_DATA_HOME, or ~/.local/share when it is unset or, as the
+// XDG base directory spec requires, not an absolute path.
+func DefaultDataDir() string {
+	if runtime.GOOS == "linux" {
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if !filepath.IsAbs(dataHome) {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return appdirs.UserDataDir("ensure-conda", "", "", false)
+			}
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		return filepath.Join(dataHome, "ensure-conda")
+	}
+	return appdirs.UserDataDir("ensure-conda", "", "", false)
+}
+
+// DataDir returns the directory executables are installed to with opts: Options.DataDir,
+// or else DefaultDataDir, in a subdirectory per platform for other platforms than this one.
+func DataDir(opts Options) string {
+	return newEnsurer(opts).dataDir
+}
+
+type ensurer struct {
+	opts            Options
+	log             log.FieldLogger
+	dataDir         string
+	minMambaVersion *version.Version
+	minCondaVersion *version.Version
+	client          *http.Client
+
+	// mambaRequirement and condaRequirement are what mamba/micromamba and
+	// conda/conda-standalone versions must satisfy; versionSpecErr is set when the
+	// specifiers in Options are invalid.
+	mambaRequirement versionRequirement
+	condaRequirement versionRequirement
+	versionSpecErr   error
+	headers          http.Header
+	authHosts        map[string]bool
+	userAgent        string
+
+	// searchProblems are the errors met by the last search for existing executables.
+	searchProblems []error
+
+	// subdir and platform are what executables are installed for.
+	subdir   string
+	platform ArchSpec
+
+	probesMu sync.Mutex
+	probes   map[probeKey]*versionProbe
+}
+
+func newEnsurer(opts Options) *ensurer {
+	e := &ensurer{
+		opts:            opts,
+		log:             opts.Logger,
+		dataDir:         opts.DataDir,
+		minMambaVersion: opts.MinMambaVersion,
+		minCondaVersion: opts.MinCondaVersion,
+		probes:          make(map[probeKey]*versionProbe),
+	}
+	if e.log == nil {
+		logger := log.New()
+		logger.Out = ioutil.Discard
+		e.log = logger
+	}
+	e.subdir = opts.Platform
+	if e.subdir == "" {
+		e.subdir = PlatformSubdir()
+	}
+	e.platform, _ = subdirPlatform(e.subdir)
+	if e.dataDir == "" {
+		e.dataDir = DefaultDataDir()
+		if e.foreignPlatform() {
+			e.dataDir = filepath.Join(e.dataDir, e.subdir)
+		}
+	}
+	if e.minMambaVersion == nil {
+		e.minMambaVersion, _ = version.NewVersion(DefaultMinMambaVersion)
+	}
+	if e.minCondaVersion == nil {
+		e.minCondaVersion, _ = version.NewVersion(DefaultMinCondaVersion)
+	}
+	e.mambaRequirement = minVersionRequirement(e.minMambaVersion)
+	e.condaRequirement = minVersionRequirement(e.minCondaVersion)
+	if opts.MambaVersionSpec != "" {
+		e.mambaRequirement, e.versionSpecErr = specVersionRequirement(opts.MambaVersionSpec)
+	}
+	if opts.CondaVersionSpec != "" && e.versionSpecErr == nil {
+		e.condaRequirement, e.versionSpecErr = specVersionRequirement(opts.CondaVersionSpec)
+	}
+	return e
+}
+
+// Resolve finds an executable matching opts.  Preexisting executables of any enabled flavor
+// are preferred over installing one.  ErrNotFound is returned when nothing suitable exists
+// and none could be installed.
+func Resolve(ctx context.Context, opts Options) (Result, error) {
+	e := newEnsurer(opts)
+	if opts.ForceInstall && opts.NoInstall {
+		return Result{}, errors.New("ForceInstall and NoInstall are mutually exclusive")
+	}
+	if opts.DownloadOnly && opts.NoInstall {
+		return Result{}, errors.New("DownloadOnly and NoInstall are mutually exclusive")
+	}
+	if err := e.checkPlatform(); err != nil {
+		return Result{}, err
+	}
+	if e.versionSpecErr != nil {
+		return Result{}, e.versionSpecErr
+	}
+	if err := checkDataDirSearch(opts.DataDirSearch); err != nil {
+		return Result{}, err
+	}
+	if opts.NoPathSearch && opts.DataDirSearch == DataDirNever {
+		return Result{}, errors.New("NoPathSearch and DataDirSearch never leave nowhere to search")
+	}
+	if err := e.checkInstallPlatform(); err != nil && (e.installable(Micromamba) || e.installable(CondaStandalone)) {
+		e.log.WithError(err).Warn("micromamba and conda-standalone can't be installed, only executables already on PATH can be used")
+	}
+
+	start := time.Now()
+	defer func() {
+		e.log.WithField("elapsed", time.Since(start)).Debug("resolve finished")
+	}()
+
+	if !opts.ForceInstall && !e.downloadOnly() {
+		result, err := e.ensure(ctx, false)
+		e.log.WithField("elapsed", time.Since(start)).Debug("searched for existing executables")
+		if result.Executable != "" {
+			e.log.Debugf("Found executable %s", result.Executable)
+			return e.withBinLayout(ctx, result)
+		}
+		if err != nil {
+			e.log.WithError(err).Debug("searching for existing executables failed")
+		}
+		if opts.PreferSystem {
+			if result := e.ensureAnyVersion(ctx); result.Executable != "" {
+				return e.withBinLayout(ctx, result)
+			}
+		}
+	}
+	if !e.installable(Micromamba) && !e.installable(CondaStandalone) {
+		return Result{}, e.notFoundError()
+	}
+	if err := ctx.Err(); err != nil {
+		return Result{}, err
+	}
+
+	e.log.Debugf("Attempting to install")
+	result, err := e.ensure(ctx, true)
+	if err != nil {
+		return Result{}, err
+	}
+	if result.Planned != nil {
+		return result, nil
+	}
+	if result.Executable == "" {
+		return Result{}, e.notFoundError()
+	}
+	e.log.Debugf("Found executable after installing %s", result.Executable)
+	return e.withBinLayout(ctx, result)
+}
+
+// installable reports whether opts allow installing flavor, which they never do for mamba
+// and conda.
+func (e *ensurer) installable(flavor Flavor) bool {
+	if e.opts.NoInstall {
+		return false
+	}
+	switch flavor {
+	case Micromamba:
+		return e.opts.Micromamba && !e.opts.NoInstallMicromamba
+	case CondaStandalone:
+		return e.opts.CondaStandalone && !e.opts.NoInstallCondaStandalone
+	}
+	return false
+}
+
+// ensureAnyVersion searches for existing executables again accepting any version they
+// report, for Options.PreferSystem.  A pinned conda-standalone version is still required.
+func (e *ensurer) ensureAnyVersion(ctx context.Context) Result {
+	mambaRequirement, condaRequirement := e.mambaRequirement, e.condaRequirement
+	defer func() {
+		e.mambaRequirement, e.condaRequirement = mambaRequirement, condaRequirement
+	}()
+	e.mambaRequirement, e.condaRequirement = anyVersionRequirement, anyVersionRequirement
+
+	result, err := e.ensure(ctx, false)
+	if result.Executable == "" {
+		if err != nil {
+			e.log.WithError(err).Debug("searching for existing executables of any version failed")
+		}
+		return result
+	}
+	wantVersion := condaRequirement.description
+	if result.Flavor == Mamba || result.Flavor == Micromamba {
+		wantVersion = mambaRequirement.description
+	}
+	e.log.WithFields(log.Fields{
+		"executable":  result.Executable,
+		"version":     result.Version.String(),
+		"wantVersion": wantVersion,
+	}).Warn("using an existing executable not meeting the version requirement, as existing executables are preferred")
+	return result
+}
+
+// notFoundError is ErrNotFound, wrapped with the problems met by the last search so that a
+// misconfigured PATH or a broken executable can be told apart from a missing one.
+func (e *ensurer) notFoundError() error {
+	if len(e.searchProblems) == 0 {
+		return ErrNotFound
+	}
+	problems := make([]string, len(e.searchProblems))
+	for i, problem := range e.searchProblems {
+		problems[i] = problem.Error()
+	}
+	return fmt.Errorf("%w; problems met while searching: %s", ErrNotFound, strings.Join(problems, "; "))
+}
+
+// noteSearchProblems records the problems of a search for an executable that failed with
+// err.
+func (e *ensurer) noteSearchProblems(err error) {
+	for _, problem := range searchProblems(err) {
+		e.log.WithError(problem).Debug("problem while searching for executable")
+		e.searchProblems = append(e.searchProblems, problem)
+	}
+}
+
+func (e *ensurer) ensure(ctx context.Context, install bool) (Result, error) {
+	search := !(install && e.opts.ForceInstall) && !e.downloadOnly()
+	e.searchProblems = nil
+	mambaVersionCheck := e.flavorCheck(Mamba)
+	microMambaVersionCheck := e.flavorCheck(Micromamba)
+	condaVersionCheck := e.flavorCheck(Conda)
+	pinnedVersion, err := e.pinnedCondaStandaloneVersion()
+	if err != nil {
+		return Result{}, err
+	}
+	installMicromamba := install && e.installable(Micromamba)
+	installCondaStandalone := install && e.installable(CondaStandalone)
+	condaStandaloneVersionCheck := condaVersionCheck
+	if pinnedVersion != nil {
+		condaStandaloneVersionCheck = e.executableHasVersion(pinnedVersion, "conda")
+	}
+
+	if e.opts.Mamba && search {
+		e.log.Debug("Checking for mamba")
+		executable, exeVersion, err := e.resolveExecutable(ctx, "mamba", e.dataDir, mambaVersionCheck)
+		if executable != "" {
+			return Result{Executable: executable, Flavor: Mamba, Version: exeVersion}, nil
+		}
+		e.noteSearchProblems(err)
+	}
+	if e.opts.Micromamba {
+		e.log.Debug("Checking for micromamba")
+		if !search {
+			e.log.Debug("Skipping preexisting executables to force an install")
+		} else {
+			executable, exeVersion, err := e.resolveExecutable(ctx, "micromamba", e.dataDir, microMambaVersionCheck)
+			if executable != "" {
+				return Result{Executable: executable, Flavor: Micromamba, Version: exeVersion}, nil
+			}
+			e.noteSearchProblems(err)
+		}
+		if installMicromamba && e.opts.DryRun {
+			if err := e.checkInstallPlatform(); err != nil {
+				return Result{}, err
+			}
+			if e.opts.MicromambaChannel == "" {
+				urls, err := e.micromambaMirrorUrls()
+				if err != nil {
+					return Result{}, err
+				}
+				return Result{Planned: &PlannedInstall{Flavor: Micromamba, Url: urls[0]}}, nil
+			}
+			channel, err := parseChannel(e.opts.MicromambaChannel)
+			if err != nil {
+				return Result{}, err
+			}
+			chosen, err := e.chooseFromChannel(ctx, channel, "micromamba")
+			if err != nil {
+				return Result{}, &InstallError{Flavor: Micromamba, Err: err}
+			}
+			return Result{Planned: &PlannedInstall{
+				Flavor:      Micromamba,
+				Url:         chosen.DownloadUrl,
+				Version:     chosen.Attrs.Version,
+				BuildNumber: chosen.Attrs.BuildNumber,
+			}}, nil
+		}
+		if installMicromamba {
+			exe, err := e.installMicromamba(ctx)
+			if err != nil {
+				return Result{}, &InstallError{Flavor: Micromamba, Err: err}
+			}
+			exeVersion, err := e.verifyInstall(ctx, exe, microMambaVersionCheck)
+			if err == nil {
+				return Result{Executable: exe, Flavor: Micromamba, Version: exeVersion}, nil
+			}
+			e.log.WithError(err).Warn("installed micromamba is not usable")
+		}
+	}
+	var rejectedConda []RejectedExecutable
+	if e.opts.Conda && search {
+		e.log.Debug("Checking for conda")
+		// TODO: check $CONDA_EXE
+		executable, exeVersion, err := e.resolveExecutable(ctx, "conda", e.dataDir, condaVersionCheck)
+		if executable != "" {
+			return Result{Executable: executable, Flavor: Conda, Version: exeVersion}, nil
+		}
+		rejectedConda = rejectedExecutables(err)
+		e.noteSearchProblems(err)
+	}
+	if installCondaStandalone && len(rejectedConda) > 0 {
+		if result, ok := e.useRejectedConda(rejectedConda); ok {
+			return result, nil
+		}
+	}
+	if e.opts.CondaStandalone {
+		e.log.Debug("Checking for conda_standalone")
+		if !search {
+			e.log.Debug("Skipping preexisting executables to force an install")
+		} else {
+			if pinnedVersion != nil && e.dataDirSearchedFirst() {
+				executable := e.targetExeFilename(e.condaStandaloneExeName())
+				if exeVersion := e.pinnedVersionFromMetadata(executable, pinnedVersion); exeVersion != nil {
+					e.log.WithField("executable", executable).Debug("install metadata records the pinned version, skipping the version check")
+					return Result{Executable: executable, Flavor: CondaStandalone, Version: exeVersion}, nil
+				}
+			}
+			executable, exeVersion, err := e.resolveExecutable(ctx, e.condaStandaloneExeName(), e.dataDir, condaStandaloneVersionCheck)
+			if executable != "" {
+				return Result{Executable: executable, Flavor: CondaStandalone, Version: exeVersion}, nil
+			}
+			e.noteSearchProblems(err)
+		}
+		if installCondaStandalone && e.opts.DryRun {
+			chosen, err := e.chooseCondaStandalone(ctx)
+			if err != nil {
+				return Result{}, &InstallError{Flavor: CondaStandalone, Err: err}
+			}
+			return Result{Planned: &PlannedInstall{
+				Flavor:      CondaStandalone,
+				Url:         chosen.DownloadUrl,
+				Version:     chosen.Attrs.Version,
+				BuildNumber: chosen.Attrs.BuildNumber,
+			}, RejectedConda: rejectedConda}, nil
+		}
+		if installCondaStandalone {
+			exe, exeVersion, err := e.installCondaStandalone(ctx)
+			if err != nil {
+				return Result{}, &InstallError{Flavor: CondaStandalone, Err: err}
+			}
+			return Result{Executable: exe, Flavor: CondaStandalone, Version: exeVersion, RejectedConda: rejectedConda}, nil
+		}
+	}
+
+	return Result{}, nil
+}
+
+type ArchSpec struct {
+	os   string
+	arch string
+}
+
+// useRejectedConda reports the condas on PATH passed over in favor of installing
+// conda-standalone, using the first whose version is known instead when
+// Options.PreferSystemConda is set.
+func (e *ensurer) useRejectedConda(rejected []RejectedExecutable) (Result, bool) {
+	for _, conda := range rejected {
+		if e.opts.PreferSystemConda && conda.Version != nil {
+			e.log.WithFields(log.Fields{
+				"executable":  conda.Executable,
+				"version":     conda.Version.String(),
+				"wantVersion": e.condaRequirement.description,
+			}).Warn("using a conda not meeting the version requirement, as the system conda is preferred")
+			return Result{Executable: conda.Executable, Flavor: Conda, Version: conda.Version, RejectedConda: rejected}, true
+		}
+	}
+	for _, conda := range rejected {
+		e.log.WithFields(log.Fields{
+			"executable":  conda.Executable,
+			"version":     VersionString(conda.Version),
+			"wantVersion": e.condaRequirement.description,
+		}).Warn("passing over a conda that doesn't meet the version requirement, installing conda-standalone instead; " +
+			"upgrade it or prefer the system conda to use it anyway")
+	}
+	return Result{}, false
+}
+
+// PlatformSubdir returns the conda subdir of the host platform, or "" when the platform
+// is not supported.
+func PlatformSubdir() string {
+	return platformSubdir(runtime.GOOS, runtime.GOARCH)
+}
+
+var platformSubdirs = map[ArchSpec]string{
+	{"darwin", "amd64"}:  "osx-64",
+	{"darwin", "arm64"}:  "osx-arm64",
+	{"linux", "amd64"}:   "linux-64",
+	{"linux", "arm64"}:   "linux-aarch64",
+	{"linux", "ppc64le"}: "linux-ppc64le",
+	{"linux", "riscv64"}: "linux-riscv64",
+	{"windows", "amd64"}: "win-64",
+	{"windows", "arm64"}: "win-arm64",
+}
+
+func platformSubdir(os_ string, arch string) string {
+	return platformSubdirs[ArchSpec{os_, arch}]
+}
+
+// PlatformSubdirs returns the conda subdirs executables can be installed for.
+func PlatformSubdirs() []string {
+	subdirs := make([]string, 0, len(platformSubdirs))
+	for _, subdir := range platformSubdirs {
+		subdirs = append(subdirs, subdir)
+	}
+	sort.Strings(subdirs)
+	return subdirs
+}
+
+// subdirPlatform is the inverse of platformSubdir.
+func subdirPlatform(subdir string) (ArchSpec, bool) {
+	for spec, specSubdir := range platformSubdirs {
+		if specSubdir == subdir {
+			return spec, true
+		}
+	}
+	return ArchSpec{}, false
+}
+
+// checkPlatform reports whether executables can be installed for Options.Platform.
+func (e *ensurer) checkPlatform() error {
+	if _, ok := subdirPlatform(e.subdir); !ok && e.opts.Platform != "" {
+		return fmt.Errorf("unsupported platform %q", e.opts.Platform)
+	}
+	return nil
+}
+
+// checkInstallPlatform fails when the host has no conda subdir, before any request is made
+// for a platform nothing is published for.
+func (e *ensurer) checkInstallPlatform() error {
+	if e.subdir == "" {
+		return fmt.Errorf("unsupported platform: %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	return nil
+}
+
+// downloadOnly reports whether installed executables must not be run, and so found
+// executables can't be checked either.
+func (e *ensurer) downloadOnly() bool {
+	return e.opts.DownloadOnly || e.foreignPlatform()
+}
+
+// foreignPlatform reports whether executables are installed for a platform other than
+// the host's, so that they can't be run.
+func (e *ensurer) foreignPlatform() bool {
+	return e.subdir != PlatformSubdir()
+}
@@ -0,0 +1,35 @@
+package ensureconda
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDefaultDataDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("XDG_DATA_HOME is only honored on linux")
+	}
+	defer os.Setenv("XDG_DATA_HOME", os.Getenv("XDG_DATA_HOME"))
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", "/home/ensureconda")
+
+	tests := []struct {
+		name     string
+		dataHome string
+		want     string
+	}{
+		{"unset", "", "/home/ensureconda/.local/share/ensure-conda"},
+		{"XDG_DATA_HOME", "/srv/data", "/srv/data/ensure-conda"},
+		{"relative XDG_DATA_HOME", "data", "/home/ensureconda/.local/share/ensure-conda"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("XDG_DATA_HOME", tt.dataHome)
+			if got := DefaultDataDir(); got != filepath.FromSlash(tt.want) {
+				t.Errorf("DefaultDataDir() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package ensureconda
+
+import "os"
+
+// executableExtensions returns the extensions tried in turn when searching for name.
+// Executables need none outside of Windows.
+func executableExtensions(name string) []string {
+	return []string{""}
+}
+
+// assertExecutable checks that file is a regular file someone may execute.
+func assertExecutable(file string) error {
+	d, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if m := d.Mode(); !m.IsDir() && m&0111 != 0 {
+		return nil
+	}
+	return os.ErrPermission
+}
@@ -0,0 +1,63 @@
+package ensureconda
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultPathExt is used when PATHEXT is unset, as cmd.exe does.
+const defaultPathExt = ".com;.exe;.bat;.cmd"
+
+// pathExts returns the lowercased extensions of executables listed in PATHEXT.
+func pathExts() []string {
+	pathExt := os.Getenv("PATHEXT")
+	if pathExt == "" {
+		pathExt = defaultPathExt
+	}
+	var exts []string
+	for _, ext := range strings.Split(strings.ToLower(pathExt), ";") {
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		exts = append(exts, ext)
+	}
+	return exts
+}
+
+// hasPathExt reports whether name ends in one of the PATHEXT extensions.
+func hasPathExt(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, pathExt := range pathExts() {
+		if ext == pathExt {
+			return true
+		}
+	}
+	return false
+}
+
+// executableExtensions returns the extensions tried in turn when searching for name, those
+// in PATHEXT so that launchers such as conda.bat are found, or none when name already has
+// one.
+func executableExtensions(name string) []string {
+	if hasPathExt(name) {
+		return []string{""}
+	}
+	return pathExts()
+}
+
+// assertExecutable checks that file is a regular file with an extension in PATHEXT, as
+// Windows has no execute permission bits.
+func assertExecutable(file string) error {
+	d, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if !d.IsDir() && hasPathExt(file) {
+		return nil
+	}
+	return os.ErrPermission
+}
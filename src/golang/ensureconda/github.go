@@ -0,0 +1,109 @@
+package ensureconda
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/go-version"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// githubReleasesUrl lists the releases of conda-standalone, which carry the bare executable
+// for each platform as assets.
+var githubReleasesUrl = "https://api.github.com/repos/conda/conda-standalone/releases"
+
+// micromambaGithubUrl serves the micromamba package of each subdir from the latest release
+// of mamba-org/micromamba-releases.
+var micromambaGithubUrl = "https://github.com/mamba-org/micromamba-releases/releases/latest/download"
+
+// micromambaGithubAssetUrl returns the url of the latest micromamba package for subdir on
+// GitHub, e.g. .../micromamba-linux-64.tar.bz2.
+func micromambaGithubAssetUrl(subdir string) string {
+	return micromambaGithubUrl + "/micromamba-" + subdir + ".tar.bz2"
+}
+
+// executableCandidate is the AnacondaPkg.Type of candidates whose DownloadUrl is a bare
+// executable rather than a package.
+const executableCandidate = "executable"
+
+type githubRelease struct {
+	TagName    string        `json:"tag_name"`
+	Draft      bool          `json:"draft"`
+	Prerelease bool          `json:"prerelease"`
+	Assets     []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name               string `json:"name"`
+	Size               uint32 `json:"size"`
+	BrowserDownloadUrl string `json:"browser_download_url"`
+}
+
+// githubAssetPlatforms maps conda subdirs to the platform part of conda-standalone's
+// release asset names, e.g. conda-standalone-24.1.2-Linux-x86_64.
+var githubAssetPlatforms = map[string]string{
+	"linux-64":      "Linux-x86_64",
+	"linux-aarch64": "Linux-aarch64",
+	"linux-ppc64le": "Linux-ppc64le",
+	"osx-64":        "MacOSX-x86_64",
+	"osx-arm64":     "MacOSX-arm64",
+	"win-64":        "Windows-x86_64",
+}
+
+// githubCandidates lists the conda-standalone executables published on GitHub for subdir,
+// sorted from oldest to newest.  Drafts and prereleases are skipped.
+func (e *ensurer) githubCandidates(ctx context.Context, subdir string) ([]AnacondaPkg, error) {
+	platform, ok := githubAssetPlatforms[subdir]
+	if !ok {
+		return nil, fmt.Errorf("no conda-standalone GitHub release assets for subdir %s", subdir)
+	}
+	suffix := "-" + platform
+	if e.platform.os == "windows" {
+		suffix += ".exe"
+	}
+
+	e.log.WithField("url", githubReleasesUrl).Debug("listing GitHub releases")
+	resp, err := e.getWithRetry(ctx, githubReleasesUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var releases []githubRelease
+	if err := json.Unmarshal(body, &releases); err != nil {
+		return nil, err
+	}
+
+	candidates := make([]AnacondaPkg, 0)
+	for _, release := range releases {
+		if release.Draft || release.Prerelease {
+			continue
+		}
+		tag := strings.TrimPrefix(release.TagName, "v")
+		if _, err := version.NewVersion(tag); err != nil {
+			e.log.WithField("tag", release.TagName).Debug("skipping release with unparseable version")
+			continue
+		}
+		for _, asset := range release.Assets {
+			if asset.Name != "conda-standalone-"+tag+suffix {
+				continue
+			}
+			candidates = append(candidates, AnacondaPkg{
+				Size:        asset.Size,
+				Attrs:       AnacondaPkgAttr{Subdir: subdir, Version: tag},
+				Type:        executableCandidate,
+				DownloadUrl: asset.BrowserDownloadUrl,
+			})
+		}
+	}
+	sort.Sort(AnacondaPkgs(candidates))
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no conda-standalone release assets found on GitHub for subdir %s", subdir)
+	}
+	return candidates, nil
+}
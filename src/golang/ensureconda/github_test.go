@@ -0,0 +1,111 @@
+package ensureconda
+
+import (
+	"context"
+	"fmt"
+	"github.com/flowchartsman/retry"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGithubCandidates(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("assets in the fixture are for unix")
+	}
+	defer func(u string) { githubReleasesUrl = u }(githubReleasesUrl)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"tag_name": "24.5.0", "prerelease": true, "assets": [{"name": "conda-standalone-24.5.0-Linux-x86_64", "browser_download_url": "https://example.com/24.5.0"}]},
+			{"tag_name": "24.4.0", "draft": true, "assets": [{"name": "conda-standalone-24.4.0-Linux-x86_64", "browser_download_url": "https://example.com/24.4.0"}]},
+			{"tag_name": "24.3.0", "assets": [
+				{"name": "conda-standalone-24.3.0-Linux-x86_64.sha256", "browser_download_url": "https://example.com/24.3.0.sha256"},
+				{"name": "conda-standalone-24.3.0-Linux-x86_64", "browser_download_url": "https://example.com/24.3.0"},
+				{"name": "conda-standalone-24.3.0-MacOSX-arm64", "browser_download_url": "https://example.com/24.3.0-arm64"}
+			]},
+			{"tag_name": "v24.1.2", "assets": [{"name": "conda-standalone-24.1.2-Linux-x86_64", "browser_download_url": "https://example.com/24.1.2"}]},
+			{"tag_name": "nightly", "assets": [{"name": "conda-standalone-nightly-Linux-x86_64", "browser_download_url": "https://example.com/nightly"}]}
+		]`)
+	}))
+	defer server.Close()
+	githubReleasesUrl = server.URL
+
+	got, err := newEnsurer(Options{}).githubCandidates(context.Background(), "linux-64")
+	if err != nil {
+		t.Fatalf("githubCandidates() error = %v", err)
+	}
+	var gotUrls []string
+	for _, c := range got {
+		gotUrls = append(gotUrls, c.DownloadUrl)
+	}
+	want := []string{"https://example.com/24.1.2", "https://example.com/24.3.0"}
+	if fmt.Sprint(gotUrls) != fmt.Sprint(want) {
+		t.Errorf("githubCandidates() got = %v, want %v", gotUrls, want)
+	}
+
+	if _, err := newEnsurer(Options{}).githubCandidates(context.Background(), "win-64"); err == nil {
+		t.Errorf("githubCandidates() expected an error when no asset matches")
+	}
+	if _, err := newEnsurer(Options{}).githubCandidates(context.Background(), "linux-s390x"); err == nil {
+		t.Errorf("githubCandidates() expected an error for an unsupported subdir")
+	}
+}
+
+func TestCondaStandaloneSourceFallback(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	defer func(r *retry.Retrier) { requestRetrier = r }(requestRetrier)
+	requestRetrier = retry.NewRetrier(2, time.Millisecond, time.Millisecond)
+	defer func(u string) { githubReleasesUrl = u }(githubReleasesUrl)
+
+	script := "#!/bin/sh\necho conda 24.3.0\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/releases":
+			fmt.Fprintf(w, `[{"tag_name": "24.3.0", "assets": [{"name": "conda-standalone-24.3.0-%s", "browser_download_url": "http://%s/asset"}]}]`,
+				githubAssetPlatforms[PlatformSubdir()], r.Host)
+		case "/asset":
+			fmt.Fprint(w, script)
+		default:
+			http.Error(w, "rate limited", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	githubReleasesUrl = server.URL + "/releases"
+
+	tests := []struct {
+		name    string
+		sources []string
+		wantErr bool
+	}{
+		{"channel only", []string{SourceChannel}, true},
+		{"github fallback", []string{SourceChannel, SourceGitHub}, false},
+		{"github only", []string{SourceGitHub}, false},
+		{"unknown source", []string{"pypi"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			opts.CondaStandaloneChannel = server.URL + "/anaconda"
+			opts.CondaStandaloneSources = tt.sources
+
+			got, err := InstallCondaStandalone(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InstallCondaStandalone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if content, _ := ioutil.ReadFile(got); !strings.Contains(string(content), "24.3.0") {
+				t.Errorf("InstallCondaStandalone() installed %q, want the GitHub release asset", content)
+			}
+		})
+	}
+}
@@ -0,0 +1,1755 @@
+package ensureconda
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/flowchartsman/retry"
+	"github.com/hashicorp/go-version"
+	"github.com/klauspost/compress/zstd"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func (e *ensurer) targetExeFilename(exeName string) string {
+	targetFileName := filepath.Join(e.dataDir, exeName)
+	if e.platform.os == "windows" {
+		targetFileName = targetFileName + ".exe"
+	}
+	return targetFileName
+}
+
+// InstallMicromamba installs the latest micromamba into the data directory and returns
+// the path of the installed executable.
+func InstallMicromamba(ctx context.Context, opts Options) (string, error) {
+	e := newEnsurer(opts)
+	if err := e.checkPlatform(); err != nil {
+		return "", err
+	}
+	return e.installMicromamba(ctx)
+}
+
+// prepareDataDir creates the data directory and checks that executables can be written to
+// it, which fails early and clearly on read-only file systems or with an invalid
+// Options.InstallMode.
+func (e *ensurer) prepareDataDir() error {
+	if _, err := e.installMode(); err != nil {
+		return err
+	}
+	err := mkdirAll(e.dataDir, e.dataDirMode())
+	if err == nil {
+		var probe *os.File
+		if probe, err = ioutil.TempFile(e.dataDir, ".write-test-*"); err == nil {
+			probe.Close()
+			_ = os.Remove(probe.Name())
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("ensureconda data directory is not writable: %s (choose another with --data-dir): %w", e.dataDir, err)
+	}
+	return nil
+}
+
+func (e *ensurer) installMicromamba(ctx context.Context) (string, error) {
+	if err := e.checkInstallPlatform(); err != nil {
+		return "", err
+	}
+	offline := e.offline()
+	if offline && (e.opts.MicromambaChannel != "" || len(localArchiveUrls(e.opts.MicromambaMirrors)) == 0) {
+		return "", fmt.Errorf("not installing micromamba: %w", ErrOffline)
+	}
+	if err := e.prepareDataDir(); err != nil {
+		return "", err
+	}
+	target := e.targetExeFilename("micromamba")
+	before, _ := newProbeKey(target)
+	installLock, err := e.newLock("micromamba_install")
+	if err != nil {
+		return "", err
+	}
+	if err := e.acquireLock(ctx, installLock); err != nil {
+		return "", err
+	}
+	defer releaseLock(installLock)
+	if e.installedMeanwhile(ctx, target, before, e.flavorCheck(Micromamba)) {
+		e.log.WithField("executable", target).Info("micromamba was installed by another process")
+		return target, nil
+	}
+
+	if e.opts.MicromambaChannel == "" {
+		urls, err := e.micromambaMirrorUrls()
+		if err != nil {
+			return "", err
+		}
+		// GitHub releases stand in when micromamba.snakepit.net is down
+		urls = append(urls, micromambaGithubAssetUrl(e.subdir))
+		if offline {
+			// Only archives staged on disk can be installed
+			urls = localArchiveUrls(urls)
+		}
+		for i, url := range urls {
+			exe, err := e.installMicromambaFrom(ctx, url)
+			if err == nil {
+				e.log.WithField("url", url).Info("installed micromamba")
+				e.writeMetadata(exe, InstallMetadata{Url: url})
+				return exe, nil
+			}
+			if ctx.Err() != nil {
+				return "", err
+			}
+			if i < len(urls)-1 {
+				e.log.WithField("url", url).WithError(err).Warn("micromamba mirror failed, trying the next one")
+				continue
+			}
+			if isNotFound(err) {
+				// newer platforms, e.g. linux-riscv64, may not have a build yet
+				return "", fmt.Errorf("no micromamba build available for %s, try --micromamba-channel conda-forge: %w", e.subdir, err)
+			}
+			return "", err
+		}
+	}
+	channel, err := parseChannel(e.opts.MicromambaChannel)
+	if err != nil {
+		return "", err
+	}
+	chosen, err := e.chooseFromChannel(ctx, channel, "micromamba")
+	if err != nil {
+		return "", err
+	}
+	e.logChosen("micromamba", chosen)
+	exe, err := e.installMicromambaFrom(ctx, chosen.DownloadUrl)
+	if err != nil {
+		return "", err
+	}
+	e.writeMetadata(exe, InstallMetadata{
+		Url:         chosen.DownloadUrl,
+		Version:     chosen.Attrs.Version,
+		BuildNumber: chosen.Attrs.BuildNumber,
+	})
+	return exe, nil
+}
+
+// installedMeanwhile reports whether target was replaced since before was taken, e.g. by
+// another process holding the install lock, with an executable passing check.
+func (e *ensurer) installedMeanwhile(ctx context.Context, target string, before probeKey, check versionCheck) bool {
+	after, err := newProbeKey(target)
+	if err != nil || (after.path == before.path && after.size == before.size && after.modTime.Equal(before.modTime)) {
+		return false
+	}
+	if e.downloadOnly() {
+		return true
+	}
+	_, ok, err := check(ctx, target)
+	return ok && err == nil
+}
+
+// micromambaApiUrl serves the latest micromamba package of each subdir at <subdir>/latest.
+var micromambaApiUrl = "https://micromamba.snakepit.net/api/micromamba"
+
+// micromambaUrl returns the url of the latest micromamba package for subdir.
+func micromambaUrl(subdir string) (string, error) {
+	if subdir == "" {
+		return "", fmt.Errorf("micromamba is not available for %s/%s", runtime.GOOS, runtime.GOARCH)
+	}
+	return fmt.Sprintf("%s/%s/latest", micromambaApiUrl, subdir), nil
+}
+
+// micromambaMirrorUrls returns the urls micromamba is downloaded from in order of
+// preference: those of Options.MicromambaMirrors followed by micromambaUrl.
+func (e *ensurer) micromambaMirrorUrls() ([]string, error) {
+	defaultUrl, err := micromambaUrl(e.subdir)
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, mirror := range e.opts.MicromambaMirrors {
+		mirrorUrl := strings.ReplaceAll(mirror, "{subdir}", e.subdir)
+		if _, local := localArchivePath(mirrorUrl); local {
+			urls = append(urls, mirrorUrl)
+			continue
+		}
+		if u, err := url.Parse(mirrorUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid micromamba mirror %q, expected an http(s) url such as "+
+				"https://mirror.example.com/micromamba/{subdir}/latest, a file:// url or an absolute path", mirror)
+		}
+		if mirrorUrl != defaultUrl {
+			urls = append(urls, mirrorUrl)
+		}
+	}
+	return append(urls, defaultUrl), nil
+}
+
+type AnacondaPkgAttr struct {
+	Subdir      string `json:"subdir"`
+	Version     string `json:"version"`
+	Build       string `json:"build"`
+	BuildNumber int32  `json:"build_number"`
+	Timestamp   uint64 `json:"timestamp"`
+	SourceUrl   string `json:"source_url"`
+	Md5         string `json:"md5"`
+}
+
+// Time returns when the package was uploaded, or the zero time when that isn't known.
+func (a AnacondaPkgAttr) Time() time.Time {
+	if a.Timestamp == 0 {
+		return time.Time{}
+	}
+	// anaconda.org timestamps are in milliseconds
+	return time.Unix(0, int64(a.Timestamp)*int64(time.Millisecond)).UTC()
+}
+
+type AnacondaPkg struct {
+	Size  uint32          `json:"size"`
+	Attrs AnacondaPkgAttr `json:"attrs"`
+	Type  string          `json:"type"`
+	// DownloadUrl is the url of the package archive.
+	DownloadUrl string `json:"download_url"`
+}
+
+// AnacondaPkgs sorts packages from oldest to newest.
+type AnacondaPkgs []AnacondaPkg
+
+func (a AnacondaPkgs) Len() int { return len(a) }
+func (a AnacondaPkgs) Less(i, j int) bool {
+	versioni, _ := version.NewVersion(a[i].Attrs.Version)
+	versionj, _ := version.NewVersion(a[j].Attrs.Version)
+	if versioni.LessThan(versionj) {
+		return true
+	} else if versionj.LessThan(versioni) {
+		return false
+	} else {
+		if a[i].Attrs.BuildNumber < a[j].Attrs.BuildNumber {
+			return true
+		} else if a[j].Attrs.BuildNumber < a[i].Attrs.BuildNumber {
+			return false
+		} else {
+			return a[i].Attrs.Timestamp < a[j].Attrs.Timestamp
+		}
+	}
+}
+func (a AnacondaPkgs) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+const defaultCondaStandaloneChannel = "anaconda"
+
+// anacondaApiUrl lists the files of packages in anaconda.org channels.
+var anacondaApiUrl = "https://api.anaconda.org/package"
+
+// maxChannelNameLength caps the length of a bare channel name, well above the longest
+// user or organization name anaconda.org hands out.
+const maxChannelNameLength = 100
+
+// configuredChannel returns the channel conda-standalone is fetched from when one is given
+// by Options.CondaStandaloneChannel or ENSURECONDA_CONDA_STANDALONE_CHANNEL, or "".
+func (e *ensurer) configuredChannel() string {
+	if e.opts.CondaStandaloneChannel != "" {
+		return e.opts.CondaStandaloneChannel
+	}
+	return os.Getenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+}
+
+// getChannelName returns the configured channel that conda-standalone is fetched from, or
+// else the default one.
+func (e *ensurer) getChannelName() (string, error) {
+	channel := e.configuredChannel()
+	if channel == "" {
+		return defaultCondaStandaloneChannel, nil
+	}
+	return parseChannel(channel)
+}
+
+// condaStandaloneChannels returns the channels conda-standalone is looked for in, in turn:
+// the configured one alone, or else the usable channels in the user's .condarc followed by
+// the default one.
+func (e *ensurer) condaStandaloneChannels() ([]string, error) {
+	if e.configuredChannel() != "" {
+		channel, err := e.getChannelName()
+		if err != nil {
+			return nil, err
+		}
+		return []string{channel}, nil
+	}
+	var channels []string
+	for _, channel := range e.condarcChannels() {
+		if channel != defaultCondaStandaloneChannel {
+			channels = append(channels, channel)
+		}
+	}
+	return append(channels, defaultCondaStandaloneChannel), nil
+}
+
+// parseChannel validates a channel given as either a bare anaconda.org channel name or
+// the full base url of an anaconda.org compatible package api,
+// e.g. https://conda.example.com/api/package/my.org
+func parseChannel(channel string) (string, error) {
+	if isChannelUrl(channel) {
+		u, err := url.Parse(channel)
+		if err != nil {
+			return "", fmt.Errorf("invalid channel url %q: %w", channel, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return "", fmt.Errorf("invalid channel url %q: scheme must be http or https", channel)
+		}
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("invalid channel url %q: missing host", channel)
+		}
+		if u.RawQuery != "" || u.Fragment != "" {
+			return "", fmt.Errorf("invalid channel url %q: query strings and fragments are not supported", channel)
+		}
+		return strings.TrimRight(channel, "/"), nil
+	}
+	if err := checkChannelName(channel); err != nil {
+		return "", err
+	}
+	return channel, nil
+}
+
+// checkChannelName validates a bare anaconda.org channel name, i.e. a user or organization
+// name.  These consist of ASCII letters, digits, '_', '-' and '.', start with a letter or
+// digit and do not end with a '.'.
+func checkChannelName(channel string) error {
+	if channel == "" {
+		return fmt.Errorf("invalid channel name: empty")
+	}
+	if len(channel) > maxChannelNameLength {
+		return fmt.Errorf("invalid channel name %q: longer than %d characters", channel, maxChannelNameLength)
+	}
+	for i, c := range channel {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '_' || c == '-' || c == '.':
+			if i == 0 {
+				return fmt.Errorf("invalid channel name %q: must start with a letter or digit, not %q", channel, c)
+			}
+		default:
+			return fmt.Errorf("invalid channel name %q: character %q at position %d is not allowed; "+
+				"use letters, digits, '_', '-' and '.', or give the full url of the channel", channel, c, i+1)
+		}
+	}
+	if strings.HasSuffix(channel, ".") {
+		return fmt.Errorf("invalid channel name %q: must not end with '.'", channel)
+	}
+	return nil
+}
+
+func isChannelUrl(channel string) bool {
+	return strings.Contains(channel, "://")
+}
+
+// packageFilesUrl builds the url listing the files of a package in a channel.
+func packageFilesUrl(channel string, pkg string) string {
+	if isChannelUrl(channel) {
+		return fmt.Sprintf("%s/%s/files", channel, pkg)
+	}
+	return fmt.Sprintf("%s/%s/%s/files", anacondaApiUrl, channel, pkg)
+}
+
+// computeCandidates lists the packages available for subdir, sorted from oldest to newest.
+// Only packages in an archive format that can be unpacked are included.
+func (e *ensurer) computeCandidates(ctx context.Context, listingUrl string, subdir string) ([]AnacondaPkg, error) {
+	e.log.WithField("url", listingUrl).Debug("listing package candidates")
+	resp, err := e.getWithRetry(ctx, listingUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []AnacondaPkg
+	err = json.Unmarshal(body, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(listingUrl)
+	if err != nil {
+		return nil, err
+	}
+	var candidates = make([]AnacondaPkg, 0)
+	for _, datum := range data {
+		if datum.Attrs.Subdir != subdir {
+			continue
+		}
+		if _, err := version.NewVersion(datum.Attrs.Version); err != nil {
+			e.log.WithField("version", datum.Attrs.Version).Debug("skipping candidate with unparseable version")
+			continue
+		}
+		// download_url is usually scheme relative, e.g. //api.anaconda.org/download/...
+		// Older listings only provide attrs.source_url.
+		if datum.DownloadUrl == "" {
+			datum.DownloadUrl = datum.Attrs.SourceUrl
+		}
+		ref, err := url.Parse(datum.DownloadUrl)
+		if err != nil || datum.DownloadUrl == "" {
+			e.log.WithField("url", datum.DownloadUrl).Debug("skipping candidate with invalid download url")
+			continue
+		}
+		datum.DownloadUrl = base.ResolveReference(ref).String()
+		if inferArchiveTypeFromUrl(datum.DownloadUrl) == "" {
+			e.log.WithField("url", datum.DownloadUrl).Debug("skipping candidate in an unsupported archive format")
+			continue
+		}
+		candidates = append(candidates, datum)
+	}
+	sort.Sort(AnacondaPkgs(candidates))
+	return candidates, nil
+}
+
+// channelCandidates lists the builds of pkg in channel for this platform from oldest to
+// newest, leaving out those skip reports.  It fails when none remain.
+func (e *ensurer) channelCandidates(
+	ctx context.Context,
+	channel string,
+	pkg string,
+	skip func(AnacondaPkg) bool) ([]AnacondaPkg, error) {
+	candidates, err := e.computeCandidates(ctx, packageFilesUrl(channel, pkg), e.subdir)
+	if isNotFound(err) {
+		// anaconda.org answers 404 for both a misspelt channel and a missing package
+		return nil, fmt.Errorf("channel %s not found or has no %s package: %w", channel, pkg, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if skip != nil {
+		kept := candidates[:0]
+		for _, candidate := range candidates {
+			if !skip(candidate) {
+				kept = append(kept, candidate)
+			}
+		}
+		candidates = kept
+	}
+	if len(candidates) == 0 {
+		return nil, &noCandidatesError{pkg: pkg, channel: channel, subdir: e.subdir}
+	}
+	return candidates, nil
+}
+
+// noCandidatesError is returned by channelCandidates when a channel has no usable build.
+type noCandidatesError struct {
+	pkg     string
+	channel string
+	subdir  string
+}
+
+func (err *noCandidatesError) Error() string {
+	return fmt.Sprintf("no %s candidates found in channel %s for subdir %s", err.pkg, err.channel, err.subdir)
+}
+
+// lacksPackage reports whether err is a channel not having the package asked for.
+func lacksPackage(err error) bool {
+	var noCandidates *noCandidatesError
+	return isNotFound(err) || errors.As(err, &noCandidates)
+}
+
+// chooseFromChannel picks the most recent build of pkg in channel for this platform.
+func (e *ensurer) chooseFromChannel(ctx context.Context, channel string, pkg string) (AnacondaPkg, error) {
+	candidates, err := e.channelCandidates(ctx, channel, pkg, nil)
+	if err != nil {
+		return AnacondaPkg{}, err
+	}
+	return candidates[len(candidates)-1], nil
+}
+
+// excludedBuilds returns the pattern of conda-standalone build strings never installed, or
+// nil when every build is acceptable.
+func (e *ensurer) excludedBuilds() (*regexp.Regexp, error) {
+	pattern := e.opts.ExcludeBuilds
+	if pattern == "" {
+		if e.opts.AllowOnedir {
+			return nil, nil
+		}
+		pattern = DefaultExcludeBuilds
+	}
+	exclude, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid build exclusion pattern: %w", err)
+	}
+	return exclude, nil
+}
+
+// condaStandaloneCandidates lists the acceptable conda-standalone builds for this platform
+// from oldest to newest, taken from the first of Options.CondaStandaloneSources that can
+// provide any.
+func (e *ensurer) condaStandaloneCandidates(ctx context.Context) ([]AnacondaPkg, error) {
+	if err := e.checkInstallPlatform(); err != nil {
+		return nil, err
+	}
+	sources := e.opts.CondaStandaloneSources
+	if len(sources) == 0 {
+		sources = []string{SourceChannel}
+	}
+	for _, source := range sources {
+		if source != SourceChannel && source != SourceGitHub {
+			return nil, fmt.Errorf("unknown conda-standalone source %q, expected %s or %s", source, SourceChannel, SourceGitHub)
+		}
+	}
+
+	var err error
+	for i, source := range sources {
+		var candidates []AnacondaPkg
+		if source == SourceGitHub {
+			candidates, err = e.githubCandidates(ctx, e.subdir)
+		} else {
+			candidates, err = e.channelCondaStandaloneCandidates(ctx)
+		}
+		if err == nil {
+			candidates, err = e.pinnedCandidates(candidates)
+		}
+		if err == nil {
+			candidates, err = e.minBuildNumberCandidates(candidates)
+		}
+		if err == nil {
+			return candidates, nil
+		}
+		if ctx.Err() != nil || i == len(sources)-1 {
+			break
+		}
+		e.log.WithField("source", source).WithError(err).Warn("conda-standalone source failed, trying the next one")
+	}
+	return nil, err
+}
+
+// pinnedCondaStandaloneVersion parses Options.CondaStandaloneVersion, returning nil when no
+// version is pinned.
+func (e *ensurer) pinnedCondaStandaloneVersion() (*version.Version, error) {
+	if e.opts.CondaStandaloneVersion == "" {
+		return nil, nil
+	}
+	pinned, err := version.NewVersion(e.opts.CondaStandaloneVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid conda-standalone version %q: %w", e.opts.CondaStandaloneVersion, err)
+	}
+	return pinned, nil
+}
+
+// pinnedCandidates keeps the candidates of the pinned conda-standalone version, if any.
+func (e *ensurer) pinnedCandidates(candidates []AnacondaPkg) ([]AnacondaPkg, error) {
+	pinned, err := e.pinnedCondaStandaloneVersion()
+	if err != nil || pinned == nil {
+		return candidates, err
+	}
+	var kept []AnacondaPkg
+	for _, candidate := range candidates {
+		if candidateVersion, err := version.NewVersion(candidate.Attrs.Version); err == nil && candidateVersion.Equal(pinned) {
+			kept = append(kept, candidate)
+		}
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("conda-standalone %s is not available for subdir %s", pinned, e.subdir)
+	}
+	return kept, nil
+}
+
+// minBuildNumberCandidates keeps the candidates with at least
+// Options.CondaStandaloneMinBuildNumber as build number.
+func (e *ensurer) minBuildNumberCandidates(candidates []AnacondaPkg) ([]AnacondaPkg, error) {
+	minBuildNumber := e.opts.CondaStandaloneMinBuildNumber
+	if minBuildNumber < 0 {
+		return nil, fmt.Errorf("invalid conda-standalone minimum build number %d", minBuildNumber)
+	}
+	if minBuildNumber == 0 {
+		return candidates, nil
+	}
+	var kept []AnacondaPkg
+	for _, candidate := range candidates {
+		if int(candidate.Attrs.BuildNumber) >= minBuildNumber {
+			kept = append(kept, candidate)
+		}
+	}
+	if len(kept) == 0 {
+		newest := candidates[len(candidates)-1]
+		return nil, fmt.Errorf("no conda-standalone build with build number %d or higher for subdir %s, the newest is %s build %d",
+			minBuildNumber, e.subdir, newest.Attrs.Version, newest.Attrs.BuildNumber)
+	}
+	return kept, nil
+}
+
+// condaStandaloneExeName is the name conda-standalone is installed as in the data directory,
+// qualified with the version when one is pinned.
+func (e *ensurer) condaStandaloneExeName() string {
+	if pinned, err := e.pinnedCondaStandaloneVersion(); err == nil && pinned != nil {
+		return "conda_standalone-" + pinned.String()
+	}
+	return "conda_standalone"
+}
+
+// channelCondaStandaloneCandidates lists the conda-standalone packages in the first of
+// condaStandaloneChannels having any, leaving out excluded builds.
+func (e *ensurer) channelCondaStandaloneCandidates(ctx context.Context) ([]AnacondaPkg, error) {
+	channels, err := e.condaStandaloneChannels()
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := e.excludedBuilds()
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := e.condaStandalonePackage()
+	if err != nil {
+		return nil, err
+	}
+	skip := func(candidate AnacondaPkg) bool {
+		if exclude != nil && exclude.MatchString(candidate.Attrs.Build) {
+			e.log.WithField("build", candidate.Attrs.Build).Debug("skipping excluded conda-standalone build")
+			return true
+		}
+		return false
+	}
+	var candidates []AnacondaPkg
+	for i, channel := range channels {
+		candidates, err = e.channelCandidates(ctx, channel, pkg, skip)
+		// Most channels in a .condarc don't carry conda-standalone
+		if i < len(channels)-1 && lacksPackage(err) {
+			e.log.WithField("channel", channel).WithError(err).Debug("channel from condarc has no conda-standalone, trying the next")
+			continue
+		}
+		break
+	}
+	return candidates, err
+}
+
+var packageNameRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// condaStandalonePackage returns the name conda-standalone is published under.
+func (e *ensurer) condaStandalonePackage() (string, error) {
+	pkg := e.opts.CondaStandalonePackage
+	if pkg == "" {
+		return DefaultCondaStandalonePackage, nil
+	}
+	if !packageNameRegex.MatchString(pkg) {
+		return "", fmt.Errorf("invalid conda-standalone package name %q", pkg)
+	}
+	return pkg, nil
+}
+
+// chooseCondaStandalone picks the most recent conda-standalone for this platform.
+func (e *ensurer) chooseCondaStandalone(ctx context.Context) (AnacondaPkg, error) {
+	candidates, err := e.condaStandaloneCandidates(ctx)
+	if err != nil {
+		return AnacondaPkg{}, err
+	}
+	return candidates[len(candidates)-1], nil
+}
+
+// ListVersions lists the builds of flavor that can be installed for Options.Platform, from
+// oldest to newest, e.g. to pick a CondaStandaloneVersion.  conda-standalone builds are
+// taken from Options.CondaStandaloneSources, leaving out excluded builds as installs do.
+// micromamba builds are only listed from Options.MicromambaChannel, as
+// micromamba.snakepit.net only serves the latest one.
+func ListVersions(ctx context.Context, opts Options, flavor Flavor) ([]AnacondaPkg, error) {
+	e := newEnsurer(opts)
+	if err := e.checkPlatform(); err != nil {
+		return nil, err
+	}
+	switch flavor {
+	case CondaStandalone:
+		return e.condaStandaloneCandidates(ctx)
+	case Micromamba:
+		if opts.MicromambaChannel == "" {
+			return nil, errors.New("only the latest micromamba is available from micromamba.snakepit.net, " +
+				"list the builds of a channel with --micromamba-channel")
+		}
+		if err := e.checkInstallPlatform(); err != nil {
+			return nil, err
+		}
+		channel, err := parseChannel(opts.MicromambaChannel)
+		if err != nil {
+			return nil, err
+		}
+		return e.channelCandidates(ctx, channel, "micromamba", nil)
+	default:
+		return nil, fmt.Errorf("%s is not installed by ensureconda, expected %s or %s", flavor, CondaStandalone, Micromamba)
+	}
+}
+
+// logChosen reports the package about to be installed, so that a user's exact executable
+// can be reproduced from their logs.
+func (e *ensurer) logChosen(pkg string, chosen AnacondaPkg) {
+	fields := log.Fields{
+		"url":         chosen.DownloadUrl,
+		"version":     chosen.Attrs.Version,
+		"buildNumber": chosen.Attrs.BuildNumber,
+	}
+	if chosen.Attrs.Build != "" {
+		fields["build"] = chosen.Attrs.Build
+	}
+	if uploaded := chosen.Attrs.Time(); !uploaded.IsZero() {
+		fields["timestamp"] = uploaded.Format(time.RFC3339)
+	}
+	e.log.WithFields(fields).Infof("installing %s %s", pkg, chosen.Attrs.Version)
+}
+
+// InstallCondaStandalone installs the most recent working conda-standalone into the data
+// directory and returns the path of the installed executable.
+func InstallCondaStandalone(ctx context.Context, opts Options) (string, error) {
+	e := newEnsurer(opts)
+	if err := e.checkPlatform(); err != nil {
+		return "", err
+	}
+	if e.versionSpecErr != nil {
+		return "", e.versionSpecErr
+	}
+	exe, _, err := e.installCondaStandalone(ctx)
+	return exe, err
+}
+
+// maxInstallAttempts bounds how many conda-standalone builds are downloaded looking for one
+// that works, as each is tens of megabytes.
+const maxInstallAttempts = 3
+
+// installCondaStandalone installs the newest conda-standalone build that passes
+// verifyInstall, falling back to older builds when a build is broken.
+func (e *ensurer) installCondaStandalone(ctx context.Context) (string, *version.Version, error) {
+	if err := e.checkInstallPlatform(); err != nil {
+		return "", nil, err
+	}
+	if e.offline() {
+		return "", nil, fmt.Errorf("not installing conda-standalone: %w", ErrOffline)
+	}
+	if err := e.prepareDataDir(); err != nil {
+		return "", nil, err
+	}
+	installLock, err := e.newLock("conda_exe_install")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := e.acquireLock(ctx, installLock); err != nil {
+		return "", nil, err
+	}
+	defer releaseLock(installLock)
+
+	check := e.flavorCheck(CondaStandalone)
+	if pinned, _ := e.pinnedCondaStandaloneVersion(); pinned != nil {
+		check = e.executableHasVersion(pinned, "conda")
+	}
+	// Builds that failed are skipped when the candidates are listed again, so that each
+	// round tries the next-best ones along with any published in the meantime
+	failed := map[string]bool{}
+	var installedExe string
+	var exeVersion *version.Version
+	var s stopper
+	err = installRetrier.RunContext(ctx, func(ctx context.Context) error {
+		var relist bool
+		var err error
+		installedExe, exeVersion, relist, err = e.installCondaStandaloneCandidate(ctx, check, failed)
+		if err != nil && !relist {
+			return s.stop(err)
+		}
+		return err
+	})
+	if err := s.result(err); err != nil {
+		return "", nil, err
+	}
+	return installedExe, exeVersion, nil
+}
+
+// installRetrier lists conda-standalone candidates again, with backoff, when none of the
+// builds tried work, e.g. while the newest build is a freshly published broken one.
+var installRetrier = retry.NewRetrier(3, 5*time.Second, 30*time.Second)
+
+// installCondaStandaloneCandidate lists conda-standalone candidates and installs the newest
+// one that works, trying at most maxInstallAttempts builds not in failed.  Builds that fail
+// are added to failed.  relist reports whether listing the candidates again might help.
+func (e *ensurer) installCondaStandaloneCandidate(ctx context.Context, check versionCheck, failed map[string]bool) (string, *version.Version, bool, error) {
+	start := time.Now()
+	candidates, err := e.condaStandaloneCandidates(ctx)
+	if err != nil {
+		return "", nil, false, err
+	}
+	e.log.WithFields(log.Fields{
+		"candidates": len(candidates),
+		"elapsed":    time.Since(start),
+	}).Debug("listed conda-standalone candidates")
+
+	var lastErr error
+	attempts := 0
+	for i := len(candidates) - 1; i >= 0 && attempts < maxInstallAttempts; i-- {
+		if err := ctx.Err(); err != nil {
+			return "", nil, false, err
+		}
+		candidate := candidates[i]
+		if failed[candidate.DownloadUrl] {
+			continue
+		}
+		attempts++
+		e.logChosen("conda-standalone", candidate)
+		var installedExe string
+		if candidate.Type == executableCandidate {
+			installedExe, err = e.downloadExecutable(ctx, candidate.DownloadUrl, e.targetExeFilename(e.condaStandaloneExeName()))
+		} else {
+			installedExe, err = e.downloadAndUnpackArchive(
+				ctx, candidate.DownloadUrl, condaStandaloneFileNameMap(e.targetExeFilename(e.condaStandaloneExeName())))
+		}
+		if err == nil {
+			e.writeMetadata(installedExe, InstallMetadata{
+				Url:         candidate.DownloadUrl,
+				Version:     candidate.Attrs.Version,
+				BuildNumber: candidate.Attrs.BuildNumber,
+			})
+			var exeVersion *version.Version
+			if exeVersion, err = e.verifyInstall(ctx, installedExe, check); err == nil {
+				return installedExe, exeVersion, false, nil
+			}
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", nil, false, ctxErr
+		}
+		e.log.WithFields(log.Fields{
+			"version": candidate.Attrs.Version,
+			"build":   candidate.Attrs.Build,
+		}).WithError(err).Warn("conda-standalone build is not usable")
+		failed[candidate.DownloadUrl] = true
+		lastErr = err
+	}
+	if attempts == 0 {
+		return "", nil, true, fmt.Errorf("no working conda-standalone: all %d builds listed failed", len(failed))
+	}
+	return "", nil, true, fmt.Errorf("no working conda-standalone among the %d newest builds: %w", maxInstallAttempts, lastErr)
+}
+
+// verifyInstall checks that a freshly installed executable reports an acceptable version
+// and, with Options.SelfTest, can run a command.  A failing executable is removed.
+func (e *ensurer) verifyInstall(ctx context.Context, exe string, check versionCheck) (*version.Version, error) {
+	if e.downloadOnly() {
+		e.log.WithField("executable", exe).Infof("not running executable downloaded for %s", e.subdir)
+		return nil, nil
+	}
+	start := time.Now()
+	exeVersion, valid, err := check(ctx, exe)
+	e.log.WithFields(log.Fields{
+		"executable": exe,
+		"elapsed":    time.Since(start),
+	}).Debug("probed installed executable's version")
+	if err != nil {
+		err = fmt.Errorf("%s --version: %w", exe, err)
+	} else if !valid {
+		err = fmt.Errorf("%s reports version %v, which is not acceptable", exe, exeVersion)
+	}
+	if err != nil {
+		_ = os.Remove(exe)
+		_ = os.Remove(metadataFileName(exe))
+		return nil, err
+	}
+	if exeVersion != nil {
+		e.recordVersion(exe, exeVersion.String())
+	}
+	if e.opts.SelfTest {
+		if err := e.selfTest(ctx, exe); err != nil {
+			return nil, err
+		}
+	}
+	return exeVersion, nil
+}
+
+var requestRetrier = retry.NewRetrier(5, 500*time.Millisecond, 10*time.Second)
+
+// stopper records the error that stops a retry loop, as retry only returns it unwrapped
+// when it stops an attempt before the last, leaving errors.Is unable to see through it.
+type stopper struct {
+	err error
+}
+
+// stop ends the retry loop with err.
+func (s *stopper) stop(err error) error {
+	s.err = err
+	return retry.Stop(err)
+}
+
+// result returns the error of a retry loop that returned err.
+func (s *stopper) result(err error) error {
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+// maxRateLimitWait bounds how long a rate limited request waits, as asked by Retry-After,
+// before retrying; longer waits fail straight away.
+var maxRateLimitWait = 30 * time.Second
+
+// getWithRetry performs a GET request, retrying with backoff on server errors and
+// transient network failures.  Client errors (4xx) are returned immediately, except for
+// rate limiting, which is waited out when the server asks for a short enough pause.
+func (e *ensurer) getWithRetry(ctx context.Context, url string) (*http.Response, error) {
+	if e.offline() {
+		return nil, fmt.Errorf("not fetching %s: %w", url, ErrOffline)
+	}
+	client, err := e.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	var resp *http.Response
+	var s stopper
+	err = requestRetrier.RunContext(ctx, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return s.stop(err)
+		}
+		if e.sendsAuthHeaders(req.URL) {
+			req.Header = e.headers.Clone()
+			e.log.WithField("url", url).WithField("headers", headerNames(e.headers)).Debug("sending auth headers")
+		}
+		req.Header.Set("User-Agent", e.userAgent)
+		r, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil && isRetryableError(err) {
+				e.log.WithField("url", url).WithError(err).Warn("request failed, retrying")
+				return err
+			}
+			return s.stop(err)
+		}
+		if r.StatusCode == http.StatusTooManyRequests {
+			wait, known := retryAfter(r.Header.Get("Retry-After"))
+			err := rateLimitError(url, r, wait, known)
+			if wait > maxRateLimitWait {
+				return s.stop(err)
+			}
+			e.log.WithField("url", url).WithField("wait", wait).Warn("rate limited, retrying")
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return s.stop(ctx.Err())
+			}
+			return err
+		}
+		if r.StatusCode >= 500 {
+			e.log.WithField("url", url).WithField("status", r.Status).Warn("request failed, retrying")
+			return responseError(url, r)
+		}
+		if r.StatusCode < 200 || r.StatusCode >= 300 {
+			return s.stop(responseError(url, r))
+		}
+		resp = r
+		return nil
+	})
+	return resp, s.result(err)
+}
+
+// responseError builds an error describing an unexpected response, including the start
+// of its body to help diagnose error pages served in place of the expected content.
+// The response body is closed.
+func responseError(url string, resp *http.Response) error {
+	defer resp.Body.Close()
+	snippet, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	return &statusError{url: url, statusCode: resp.StatusCode, status: resp.Status, snippet: strings.TrimSpace(string(snippet))}
+}
+
+// statusError is an unexpected response to a request.
+type statusError struct {
+	url        string
+	statusCode int
+	status     string
+	snippet    string
+}
+
+func (err *statusError) Error() string {
+	return fmt.Sprintf("GET %s: unexpected response %s: %s", err.url, err.status, err.snippet)
+}
+
+// isNotFound reports whether err is a 404 response.
+func isNotFound(err error) bool {
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.statusCode == http.StatusNotFound
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as a date, reporting whether
+// it held a delay.
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// rateLimitError describes a 429 response, closing its body.
+func rateLimitError(rawUrl string, resp *http.Response, wait time.Duration, known bool) error {
+	resp.Body.Close()
+	host := rawUrl
+	if u, err := url.Parse(rawUrl); err == nil {
+		host = u.Host
+	}
+	if !known {
+		return fmt.Errorf("GET %s: rate limited by %s", rawUrl, host)
+	}
+	return fmt.Errorf("GET %s: rate limited by %s, retry after %d seconds", rawUrl, host, int(wait.Round(time.Second)/time.Second))
+}
+
+func isRetryableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTemporary {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+const (
+	archiveTarBz2 = ".tar.bz2"
+	archiveTarZst = ".tar.zst"
+	archiveConda  = ".conda"
+)
+
+// inferArchiveTypeFromUrl returns the package archive format of url by its extension,
+// ignoring case and any query string, or "" when it has none of the .tar.bz2, .tar.zst and
+// .conda extensions.
+func inferArchiveTypeFromUrl(rawUrl string) string {
+	p := rawUrl
+	if u, err := url.Parse(rawUrl); err == nil {
+		p = u.Path
+	}
+	p = strings.ToLower(p)
+	switch {
+	case strings.HasSuffix(p, archiveConda):
+		return archiveConda
+	case strings.HasSuffix(p, archiveTarBz2):
+		return archiveTarBz2
+	case strings.HasSuffix(p, archiveTarZst):
+		return archiveTarZst
+	}
+	return ""
+}
+
+// inferArchiveType returns the package archive format of a download from rawUrl, which
+// was served from finalUrl after any redirects.  The extension of finalUrl is trusted over
+// that of rawUrl; urls without one, such as micromamba's /latest endpoint, are recognized by
+// the Content-Type of the response or else by the magic bytes at the start of body.
+func inferArchiveType(rawUrl string, finalUrl string, contentType string, body *bufio.Reader) (string, error) {
+	for _, u := range []string{finalUrl, rawUrl} {
+		if archiveType := inferArchiveTypeFromUrl(u); archiveType != "" {
+			return archiveType, nil
+		}
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch mediaType {
+		case "application/x-bzip2", "application/x-bzip", "application/bzip2":
+			return archiveTarBz2, nil
+		case "application/zip", "application/x-zip-compressed":
+			return archiveConda, nil
+		case "application/zstd", "application/x-zstd":
+			return archiveTarZst, nil
+		}
+	}
+	magic, err := body.Peek(4)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	switch {
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return archiveTarBz2, nil
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")):
+		return archiveConda, nil
+	case bytes.HasPrefix(magic, []byte("\x28\xb5\x2f\xfd")):
+		return archiveTarZst, nil
+	}
+	return "", fmt.Errorf("unrecognized package archive at %s, expected a .tar.bz2, .tar.zst or .conda package", rawUrl)
+}
+
+// downloadRetrier downloads archives cut short again, as flaky CDNs sometimes serve them.
+var downloadRetrier = retry.NewRetrier(3, time.Second, 5*time.Second)
+
+// downloadAndUnpackArchive downloads the conda package at url and extracts the files in
+// fileNameMap from it, returning the path of the extracted file.  Truncated archives are
+// downloaded again before giving up.
+func (e *ensurer) downloadAndUnpackArchive(
+	ctx context.Context,
+	url string,
+	fileNameMap map[string]string) (string, error) {
+	var file string
+	var s stopper
+	err := downloadRetrier.RunContext(ctx, func(ctx context.Context) error {
+		var err error
+		file, err = e.downloadAndUnpackArchiveOnce(ctx, url, fileNameMap)
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, errArchiveTruncated) && ctx.Err() == nil {
+			e.log.WithField("url", url).WithError(err).Warn("download was cut short, retrying")
+			return err
+		}
+		return s.stop(err)
+	})
+	return file, s.result(err)
+}
+
+// localArchivePath returns the file named by a file:// url or an absolute path, for
+// archives staged on disk ahead of an offline install.
+func localArchivePath(rawUrl string) (string, bool) {
+	if filepath.IsAbs(rawUrl) {
+		return rawUrl, true
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") {
+		return "", false
+	}
+	p := u.Path
+	// file:///C:/path has the path /C:/path
+	if runtime.GOOS == "windows" && len(p) > 2 && p[0] == '/' && p[2] == ':' {
+		p = p[1:]
+	}
+	return filepath.FromSlash(p), p != ""
+}
+
+// localArchiveUrls returns those of urls naming archives on disk.
+func localArchiveUrls(urls []string) []string {
+	var local []string
+	for _, u := range urls {
+		if _, ok := localArchivePath(u); ok {
+			local = append(local, u)
+		}
+	}
+	return local
+}
+
+// openArchive requests rawUrl, reading local archives from disk instead, so that they go
+// through the same archive type inference and extraction as downloaded ones.
+func (e *ensurer) openArchive(ctx context.Context, rawUrl string) (*http.Response, error) {
+	localPath, ok := localArchivePath(rawUrl)
+	if !ok {
+		return e.getWithRetry(ctx, rawUrl)
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err == nil && st.IsDir() {
+		err = fmt.Errorf("%s is a directory, not a package archive", localPath)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	e.log.WithField("path", localPath).Debug("reading archive from disk")
+	fileUrl := &url.URL{Scheme: "file", Path: filepath.ToSlash(localPath)}
+	if !strings.HasPrefix(fileUrl.Path, "/") {
+		fileUrl.Path = "/" + fileUrl.Path
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		Body:          f,
+		ContentLength: st.Size(),
+		Request:       &http.Request{Method: http.MethodGet, URL: fileUrl},
+	}, nil
+}
+
+func (e *ensurer) downloadAndUnpackArchiveOnce(
+	ctx context.Context,
+	url string,
+	fileNameMap map[string]string) (string, error) {
+	start := time.Now()
+	resp, err := e.openArchive(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return "", responseError(url, resp)
+	}
+	defer resp.Body.Close()
+	requested := time.Now()
+	timed := &timedReader{r: resp.Body}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{timed, resp.Body}
+
+	buffered := bufio.NewReader(resp.Body)
+	archiveType, err := inferArchiveType(url, resp.Request.URL.String(), resp.Header.Get("Content-Type"), buffered)
+	if err != nil {
+		return "", err
+	}
+	body := io.Reader(buffered)
+	if e.opts.KeepArchiveDir != "" {
+		kept, err := e.keepArchive(resp, body, archiveType)
+		if err != nil {
+			return "", err
+		}
+		defer kept.Close()
+		body = kept
+	}
+
+	var file string
+	switch archiveType {
+	case archiveConda:
+		file, err = e.downloadAndUnpackConda(ctx, body, fileNameMap)
+	case archiveTarZst:
+		file, err = e.downloadAndUnpackTarZst(ctx, body, fileNameMap)
+	default:
+		file, err = e.extractTarFiles(ctx, tar.NewReader(bzip2.NewReader(body)), fileNameMap)
+	}
+	if err != nil {
+		return "", err
+	}
+	// Downloading and unpacking are interleaved, so the time spent waiting for the network
+	// is told apart by timing reads of the response.
+	e.log.WithFields(log.Fields{
+		"url":      url,
+		"bytes":    timed.n,
+		"request":  requested.Sub(start),
+		"download": timed.elapsed,
+		"extract":  time.Since(requested) - timed.elapsed,
+	}).Debug("downloaded and unpacked archive")
+	if err := e.prepareExecutable(file); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// timedReader measures the time spent reading from r.
+type timedReader struct {
+	r       io.Reader
+	n       int64
+	elapsed time.Duration
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.elapsed += time.Since(start)
+	t.n += int64(n)
+	return n, err
+}
+
+// downloadExecutable downloads an executable published as is, rather than in a package,
+// to targetFileName.
+func (e *ensurer) downloadExecutable(ctx context.Context, url string, targetFileName string) (string, error) {
+	resp, err := e.openArchive(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return "", responseError(url, resp)
+	}
+	defer resp.Body.Close()
+
+	e.log.WithFields(log.Fields{
+		"url":     url,
+		"dstPath": targetFileName,
+	}).Debug("downloading executable")
+	start := time.Now()
+	if err := e.writeFile(ctx, targetFileName, resp.Body, resp.ContentLength); err != nil {
+		return "", err
+	}
+	e.log.WithFields(log.Fields{
+		"url":      url,
+		"download": time.Since(start),
+	}).Debug("downloaded executable")
+	if err := e.prepareExecutable(targetFileName); err != nil {
+		return "", err
+	}
+	return targetFileName, nil
+}
+
+// prepareExecutable makes sure a freshly written executable can be launched, removing it
+// otherwise.  Its architecture was checked by writeFile.  Executables for another platform
+// are never run here, so they are left as downloaded.
+func (e *ensurer) prepareExecutable(file string) error {
+	if e.foreignPlatform() {
+		return nil
+	}
+	e.clearQuarantine(file)
+	if e.opts.VerifyCodesign {
+		if err := e.verifyCodesign(file); err != nil {
+			_ = os.Remove(file)
+			return err
+		}
+	}
+	return nil
+}
+
+// keepArchive saves body, the archive downloaded by resp, to Options.KeepArchiveDir under
+// the name it has at the end of any redirects, and returns the saved file ready to be read
+// back.  The extension of archiveType is added to names lacking it.
+func (e *ensurer) keepArchive(resp *http.Response, body io.Reader, archiveType string) (*os.File, error) {
+	if err := os.MkdirAll(e.opts.KeepArchiveDir, 0700); err != nil {
+		return nil, err
+	}
+	name := path.Base(resp.Request.URL.Path)
+	if inferArchiveTypeFromUrl(name) != archiveType {
+		name += archiveType
+	}
+	keptFileName := filepath.Join(e.opts.KeepArchiveDir, name)
+	kept, err := os.Create(keptFileName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(kept, body); err != nil {
+		kept.Close()
+		return nil, err
+	}
+	if _, err := kept.Seek(0, io.SeekStart); err != nil {
+		kept.Close()
+		return nil, err
+	}
+	e.log.WithField("path", keptFileName).Info("kept downloaded archive")
+	return kept, nil
+}
+
+// downloadAndUnpackConda extracts files from a .conda package, a zip archive holding the
+// package contents in a pkg-*.tar.zst member next to its metadata in info-*.tar.zst.  The
+// zip is spooled to a temporary file as its directory is at the end.
+func (e *ensurer) downloadAndUnpackConda(ctx context.Context, body io.Reader, fileNameMap map[string]string) (string, error) {
+	tmp, err := ioutil.TempFile(e.dataDir, "download-*.conda")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, body)
+	if err != nil {
+		return "", archiveReadError(err)
+	}
+	zipReader, err := zip.NewReader(tmp, size)
+	if errors.Is(err, zip.ErrFormat) {
+		// The directory of a zip is at its end, so a download cut short lacks it
+		return "", fmt.Errorf("%w, was the download interrupted? %v", errArchiveTruncated, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, member := range zipReader.File {
+		if !isCondaPkgMember(member.Name) {
+			continue
+		}
+		r, err := member.Open()
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+		return e.extractTarFiles(ctx, tar.NewReader(zr), fileNameMap)
+	}
+	return "", errors.New("could not find pkg-*.tar.zst in the .conda archive")
+}
+
+// downloadAndUnpackTarZst extracts the files in fileNameMap from a bare zstd compressed
+// tarball, as some channels serve instead of a .conda package.  Unlike a .conda package it
+// is streamed, needing no temporary copy.
+func (e *ensurer) downloadAndUnpackTarZst(ctx context.Context, body io.Reader, fileNameMap map[string]string) (string, error) {
+	zr, err := zstd.NewReader(body)
+	if err != nil {
+		return "", archiveReadError(err)
+	}
+	defer zr.Close()
+	return e.extractTarFiles(ctx, tar.NewReader(zr), fileNameMap)
+}
+
+// isCondaPkgMember reports whether name is the member of a .conda archive holding the
+// package contents, whatever the package is called.
+func isCondaPkgMember(name string) bool {
+	return path.Dir(name) == "." && strings.HasPrefix(name, "pkg-") && strings.HasSuffix(name, ".tar.zst")
+}
+
+func (e *ensurer) installMicromambaFrom(ctx context.Context, url string) (string, error) {
+	installedExe, err := e.downloadAndUnpackArchive(ctx, url, micromambaFileNameMap(e.targetExeFilename("micromamba")))
+
+	return installedExe, err
+}
+
+// micromambaFileNameMap maps where micromamba lives in its package to target.  Windows
+// packages, for amd64 and arm64 alike, keep executables under Library/bin.  Should a build
+// move it elsewhere, micromamba found in any other directory is taken instead.
+func micromambaFileNameMap(target string) map[string]string {
+	return map[string]string{
+		"Library/bin/micromamba.exe": target,
+		"bin/micromamba":             target,
+		"**/micromamba.exe":          target,
+		"**/micromamba":              target,
+	}
+}
+
+// condaStandaloneFileNameMap maps where conda.exe lives in conda-standalone packages to
+// target.  The layout has shifted between versions, so conda.exe, or conda, directly in a
+// standalone_conda directory at any depth matches.  The _conda.exe of onedir builds needs
+// the directory next to it and is left alone.
+func condaStandaloneFileNameMap(target string) map[string]string {
+	return map[string]string{
+		"standalone_conda/conda.exe":   target,
+		"standalone_conda/conda":       target,
+		"*/standalone_conda/conda.exe": target,
+		"*/standalone_conda/conda":     target,
+	}
+}
+
+// extractTarFiles writes the first member of the tarball named in fileNameMap to its
+// target.  Names may be path.Match patterns.  A name of the form **/file matches file in
+// any directory, but only when nothing else matched by the end of the tarball; package
+// metadata under info/ never does.  Members may be symlinks or hard links to the real file;
+// as the tarball can only be read forwards, regular files next to the wanted ones are
+// spooled to the data directory in case a later link points back at them.
+func (e *ensurer) extractTarFiles(ctx context.Context, tarReader *tar.Reader, fileNameMap map[string]string) (string, error) {
+	wanted := make(map[string]string, len(fileNameMap))
+	dirs := make(map[string]bool)
+	anywhere := make(map[string]string)
+	for name, targetFileName := range fileNameMap {
+		if strings.HasPrefix(name, "**/") {
+			anywhere[strings.TrimPrefix(name, "**/")] = targetFileName
+			continue
+		}
+		wanted[name] = targetFileName
+		dirs[path.Dir(name)] = true
+	}
+	// fallbackTarget returns the target of a member found outside the expected directories
+	fallbackTarget := func(name string) string {
+		if strings.HasPrefix(name, "info/") {
+			return ""
+		}
+		return anywhere[path.Base(name)]
+	}
+	// wantedTarget returns the target of a member, or "" when it isn't wanted
+	wantedTarget := func(name string) string {
+		if targetFileName := wanted[name]; targetFileName != "" {
+			return targetFileName
+		}
+		for pattern, targetFileName := range fileNameMap {
+			if strings.HasPrefix(pattern, "**/") {
+				continue
+			}
+			if matched, _ := path.Match(pattern, name); matched {
+				return targetFileName
+			}
+		}
+		return ""
+	}
+	inWantedDir := func(name string) bool {
+		dir := path.Dir(name)
+		if dirs[dir] {
+			return true
+		}
+		for pattern := range fileNameMap {
+			if strings.HasPrefix(pattern, "**/") {
+				continue
+			}
+			if matched, _ := path.Match(path.Dir(pattern), dir); matched {
+				return true
+			}
+		}
+		return false
+	}
+	links := make(map[string]string)
+	spooled := make(map[string]string)
+	var fallback string
+	defer func() {
+		for _, spoolFileName := range spooled {
+			_ = os.Remove(spoolFileName)
+		}
+	}()
+
+	for true {
+		header, err := tarReader.Next()
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", archiveReadError(err)
+		}
+
+		name := tarMemberName(header.Name)
+		switch header.Typeflag {
+		case tar.TypeReg:
+			targetFileName := wantedTarget(name)
+			if targetFileName != "" {
+				if err := e.extractTarFile(ctx, header, targetFileName, tarReader); err != nil {
+					return "", archiveReadError(err)
+				}
+				return targetFileName, nil
+			}
+			isFallback := fallback == "" && fallbackTarget(name) != ""
+			if inWantedDir(name) || isFallback {
+				spoolFileName, err := e.spoolTarFile(tarReader)
+				if err != nil {
+					return "", archiveReadError(err)
+				}
+				spooled[name] = spoolFileName
+			}
+			if isFallback {
+				fallback = name
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			linked := tarMemberName(header.Linkname)
+			if header.Typeflag == tar.TypeSymlink {
+				if path.IsAbs(header.Linkname) {
+					continue
+				}
+				linked = path.Join(path.Dir(name), header.Linkname)
+			}
+			links[name] = linked
+			targetFileName := wantedTarget(name)
+			if targetFileName == "" {
+				continue
+			}
+			// Follow links to earlier links, giving up on cycles
+			for i := 0; i < 40 && links[linked] != ""; i++ {
+				linked = links[linked]
+			}
+			e.log.WithFields(log.Fields{
+				"srcPath": name,
+				"linked":  linked,
+			}).Debug("following link in tarball")
+			if spoolFileName, ok := spooled[linked]; ok {
+				if err := e.extractSpooledFile(ctx, spoolFileName, targetFileName); err != nil {
+					return "", err
+				}
+				return targetFileName, nil
+			}
+			wanted[linked] = targetFileName
+			dirs[path.Dir(linked)] = true
+		}
+	}
+	if fallback != "" {
+		targetFileName := fallbackTarget(fallback)
+		e.log.WithField("srcPath", fallback).Warn("executable found at an unexpected path in the package archive")
+		if err := e.extractSpooledFile(ctx, spooled[fallback], targetFileName); err != nil {
+			return "", err
+		}
+		return targetFileName, nil
+	}
+	var names []string
+	for name := range fileNameMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("could not find %s in the package archive, which was read to its end; "+
+		"the layout of the package may have changed", strings.Join(names, " or "))
+}
+
+// errArchiveTruncated is wrapped by errors reading a package archive that ends early, as
+// a download cut short does.
+var errArchiveTruncated = errors.New("package archive is truncated")
+
+// archiveReadError explains an error reading a package archive.  Archives ending early are
+// reported as truncated and malformed ones as corrupt, telling a broken download apart from
+// a missing file.
+func archiveReadError(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w, was the download interrupted? %v", errArchiveTruncated, err)
+	}
+	var structuralErr bzip2.StructuralError
+	if errors.Is(err, tar.ErrHeader) || errors.Is(err, zip.ErrChecksum) || errors.As(err, &structuralErr) {
+		return fmt.Errorf("package archive is corrupt: %w", err)
+	}
+	return err
+}
+
+// tarMemberName normalizes a tarball member name such as ./bin/micromamba to the
+// bin/micromamba form used in file name maps.
+func tarMemberName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// spoolTarFile copies the current tarball member to a temporary file in the data directory.
+func (e *ensurer) spoolTarFile(tarReader *tar.Reader) (string, error) {
+	tmp, err := ioutil.TempFile(e.dataDir, "extract-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(tmp, tarReader)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+func (e *ensurer) extractSpooledFile(ctx context.Context, spoolFileName string, targetFileName string) error {
+	f, err := os.Open(spoolFileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return e.writeFile(ctx, targetFileName, f, -1)
+}
+
+func (e *ensurer) extractTarFile(ctx context.Context, header *tar.Header, targetFileName string, tarReader *tar.Reader) (err error) {
+	e.log.WithFields(log.Fields{
+		"srcPath": header.Name,
+		"dstPath": targetFileName,
+	}).Debug("extracting from tarball")
+
+	return e.writeFile(ctx, targetFileName, tarReader, header.Size)
+}
+
+// executablePerm is the default mode of installed executables, whatever the archive says,
+// so that they can be run by other users sharing the data directory.
+const executablePerm os.FileMode = 0755
+
+// installMode returns the mode installed executables are given.
+func (e *ensurer) installMode() (os.FileMode, error) {
+	mode := e.opts.InstallMode
+	if mode == 0 {
+		return executablePerm, nil
+	}
+	if mode&^os.ModePerm != 0 || mode&0100 == 0 {
+		return 0, fmt.Errorf("invalid install mode %04o: want permission bits including the owner's execute bit", uint32(mode))
+	}
+	return mode, nil
+}
+
+// dataDirMode returns the mode of the data and lock directories ensureconda creates, so
+// that whoever the install mode lets run the executables can reach them, and whoever it
+// lets replace them can do so.
+func (e *ensurer) dataDirMode() os.FileMode {
+	installMode, err := e.installMode()
+	if err != nil {
+		installMode = executablePerm
+	}
+	mode := os.FileMode(0700)
+	for _, class := range []os.FileMode{0070, 0007} {
+		if bits := installMode & class; bits != 0 {
+			mode |= bits | class&0111
+		}
+	}
+	return mode
+}
+
+// mkdirAll is os.MkdirAll giving dir, when it has to be created, exactly mode whatever the
+// umask.
+func mkdirAll(dir string, mode os.FileMode) error {
+	_, statErr := os.Stat(dir)
+	if err := os.MkdirAll(dir, mode); err != nil {
+		return err
+	}
+	if os.IsNotExist(statErr) {
+		return os.Chmod(dir, mode)
+	}
+	return nil
+}
+
+// writeFile writes the contents of src to targetFileName while holding its lock, as an
+// executable replacing any existing file.  When size isn't negative, src must hold exactly
+// that many bytes.  Waiting for the lock stops once ctx is done.
+func (e *ensurer) writeFile(ctx context.Context, targetFileName string, src io.Reader, size int64) (err error) {
+	mode, err := e.installMode()
+	if err != nil {
+		return err
+	}
+	r, err := e.fileLockRetrier()
+	if err != nil {
+		return err
+	}
+	fileLock, err := e.newLock(filepath.Base(targetFileName))
+	if err != nil {
+		return err
+	}
+	// Write next to the target and rename once complete, so that an interrupted
+	// download never leaves a partial executable behind.
+	tmpFileName := targetFileName + ".tmp"
+
+	defer func() {
+		if !fileLock.Locked() {
+			return
+		}
+		// Nothing is left to remove once the temporary file has been renamed
+		_ = os.Remove(tmpFileName)
+		_ = fileLock.Unlock()
+	}()
+
+	waiting := false
+	var s stopper
+	err = r.RunContext(ctx, func(ctx context.Context) error {
+		locked, err := fileLock.TryLock()
+		if err != nil {
+			return err
+		}
+		if !locked {
+			if !waiting {
+				e.log.WithField("lockPath", fileLock.Path()).Info("waiting for another process writing the same file")
+				waiting = true
+			}
+			return fmt.Errorf("could not lock %s, held by another process", fileLock.Path())
+		}
+
+		file, err := os.OpenFile(tmpFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		n, cpErr := io.Copy(file, src)
+		if closeErr := file.Close(); closeErr != nil { // close file immediately
+			return closeErr
+		}
+		// The source stream has been consumed, so failures past this point can't be retried.
+		if cpErr != nil {
+			return s.stop(cpErr)
+		}
+		if size >= 0 && n != size {
+			return s.stop(fmt.Errorf("unexpected bytes written: wrote %d, want %d", n, size))
+		}
+		if n == 0 {
+			return s.stop(fmt.Errorf("refusing to install %s as it is empty", filepath.Base(targetFileName)))
+		}
+		// The umask may have masked the mode given to OpenFile
+		if err := os.Chmod(tmpFileName, mode); err != nil {
+			return s.stop(err)
+		}
+		// Checked before the target is replaced, so that a download for another architecture
+		// never takes the place of a working executable.
+		if err := e.checkExecutableArch(tmpFileName, e.platform.arch); err != nil {
+			return s.stop(fmt.Errorf("installing %s: %w", filepath.Base(targetFileName), err))
+		}
+		// The mtime records when the executable was installed, whatever the filesystem
+		// preserves on rename, since cached version probes are keyed on it.
+		now := time.Now()
+		if err := os.Chtimes(tmpFileName, now, now); err != nil {
+			return s.stop(err)
+		}
+		if err := replaceFile(tmpFileName, targetFileName); err != nil {
+			return s.stop(err)
+		}
+		return nil
+	})
+	if err != nil && s.err == nil && ctx.Err() != nil {
+		return fmt.Errorf("waiting for file lock %s: %w", fileLock.Path(), ctx.Err())
+	}
+	return s.result(err)
+}
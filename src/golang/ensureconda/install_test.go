@@ -0,0 +1,2223 @@
+package ensureconda
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/flowchartsman/retry"
+	"github.com/hashicorp/go-version"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var pathExt = ""
+
+func initTetEnv() Options {
+	log.SetLevel(log.DebugLevel)
+	if runtime.GOOS == "windows" {
+		pathExt = ".exe"
+	}
+	dir, err := ioutil.TempDir("", "ensureconda")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return Options{DataDir: dir, Logger: log.StandardLogger()}
+}
+
+// serveFixtures stands in for micromamba.snakepit.net and api.anaconda.org, serving the
+// latest micromamba from testdata and a conda-standalone package printing condaVersion.
+func serveFixtures(t *testing.T, condaVersion string) func() {
+	micromamba, err := ioutil.ReadFile(filepath.Join("testdata", "micromamba-latest.tar.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	condaStandalone := makeCondaPackage(t, "conda-standalone", map[string]string{
+		"standalone_conda/conda.exe": "#!/bin/sh\necho conda " + condaVersion + "\n",
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/micromamba/") && strings.HasSuffix(r.URL.Path, "/latest"):
+			w.Write(micromamba)
+		case r.URL.Path == "/package/anaconda/conda-standalone/files":
+			fmt.Fprintf(w, `[{"attrs": {"subdir": %q, "version": %q}, "download_url": "/download/conda-standalone.conda"}]`,
+				PlatformSubdir(), condaVersion)
+		case r.URL.Path == "/download/conda-standalone.conda":
+			w.Write(condaStandalone)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	savedMicromamba, savedGithub, savedAnaconda := micromambaApiUrl, micromambaGithubUrl, anacondaApiUrl
+	micromambaApiUrl = server.URL + "/micromamba"
+	micromambaGithubUrl = server.URL + "/github"
+	anacondaApiUrl = server.URL + "/package"
+	return func() {
+		micromambaApiUrl, micromambaGithubUrl, anacondaApiUrl = savedMicromamba, savedGithub, savedAnaconda
+		server.Close()
+	}
+}
+
+func TestInstallMicromamba(t *testing.T) {
+	defer serveFixtures(t, "24.3.0")()
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"simple",
+			filepath.FromSlash(path.Join(opts.DataDir, fmt.Sprintf("micromamba%s", pathExt))),
+			false,
+		},
+		// TODO: Add test cases.
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got, err := InstallMicromamba(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("InstallMicromamba() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("InstallMicromamba() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMicromambaUrl(t *testing.T) {
+	tests := []struct {
+		goos    string
+		goarch  string
+		want    string
+		wantErr bool
+	}{
+		{"linux", "amd64", "https://micromamba.snakepit.net/api/micromamba/linux-64/latest", false},
+		{"darwin", "arm64", "https://micromamba.snakepit.net/api/micromamba/osx-arm64/latest", false},
+		{"windows", "amd64", "https://micromamba.snakepit.net/api/micromamba/win-64/latest", false},
+		{"windows", "arm64", "https://micromamba.snakepit.net/api/micromamba/win-arm64/latest", false},
+		{"linux", "riscv64", "https://micromamba.snakepit.net/api/micromamba/linux-riscv64/latest", false},
+		{"windows", "386", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goos+"/"+tt.goarch, func(t *testing.T) {
+			got, err := micromambaUrl(platformSubdir(tt.goos, tt.goarch))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("micromambaUrl() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("micromambaUrl() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstallCondaStandalone(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	defer serveFixtures(t, "24.3.0")()
+	_, restore := isolateCondarc(t)
+	defer restore()
+	defer os.Setenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL", os.Getenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL"))
+	os.Unsetenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"simple",
+			filepath.FromSlash(path.Join(opts.DataDir, fmt.Sprintf("conda_standalone%s", pathExt))),
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := InstallCondaStandalone(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("InstallCondaStandalone() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("InstallCondaStandalone() got = %v, want %v", got, tt.want)
+			}
+
+			exeVersion, _ := version.NewVersion("4.8.0")
+			_, hasVersion, err := newEnsurer(opts).executableHasMinVersion(exeVersion, "conda")(context.Background(), got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("InstallCondaStandalone() error = %v", err)
+			}
+			if !hasVersion {
+				t.Errorf("InstallCondaStandalone() didn't match minimal versions")
+			}
+
+		})
+	}
+}
+
+func TestGetChannelName(t *testing.T) {
+	_, restore := isolateCondarc(t)
+	defer restore()
+	defer os.Unsetenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+
+	tests := []struct {
+		name    string
+		channel string
+		want    string
+		wantUrl string
+		wantErr bool
+	}{
+		{"default", "", "anaconda", "https://api.anaconda.org/package/anaconda/conda-standalone/files", false},
+		{"bare name", "conda-forge", "conda-forge", "https://api.anaconda.org/package/conda-forge/conda-standalone/files", false},
+		{"dotted org", "my.org", "my.org", "https://api.anaconda.org/package/my.org/conda-standalone/files", false},
+		{"full url",
+			"https://conda.example.com:8443/api/package/my.org/",
+			"https://conda.example.com:8443/api/package/my.org",
+			"https://conda.example.com:8443/api/package/my.org/conda-standalone/files",
+			false,
+		},
+		{"slash in name", "foo/bar", "", "", true},
+		{"leading dot", ".hidden", "", "", true},
+		{"trailing dot", "my.org.", "", "", true},
+		{"space in name", "my org", "", "", true},
+		{"non-ascii name", "forgé", "", "", true},
+		{"too long", strings.Repeat("a", maxChannelNameLength+1), "", "", true},
+		{"longest", strings.Repeat("a", maxChannelNameLength), strings.Repeat("a", maxChannelNameLength),
+			"https://api.anaconda.org/package/" + strings.Repeat("a", maxChannelNameLength) + "/conda-standalone/files", false},
+		{"bad scheme", "ftp://conda.example.com/my.org", "", "", true},
+		{"missing host", "https:///my.org", "", "", true},
+		{"query string", "https://conda.example.com/my.org?x=1", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL", tt.channel)
+			got, err := newEnsurer(Options{}).getChannelName()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getChannelName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("getChannelName() got = %v, want %v", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotUrl := packageFilesUrl(got, "conda-standalone"); gotUrl != tt.wantUrl {
+				t.Errorf("packageFilesUrl() got = %v, want %v", gotUrl, tt.wantUrl)
+			}
+		})
+	}
+}
+
+func TestGetWithRetry(t *testing.T) {
+	defer func(r *retry.Retrier) { requestRetrier = r }(requestRetrier)
+	requestRetrier = retry.NewRetrier(5, time.Millisecond, time.Millisecond)
+
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantCalls int
+		wantErr   bool
+	}{
+		{"ok", []int{200}, 1, false},
+		{"transient server error", []int{502, 503, 200}, 3, false},
+		{"not found is not retried", []int{404}, 1, true},
+		{"persistent server error", []int{500, 500, 500, 500, 500}, 5, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[calls])
+				calls++
+			}))
+			defer server.Close()
+
+			resp, err := newEnsurer(Options{}).getWithRetry(context.Background(), server.URL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getWithRetry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("getWithRetry() made %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGetWithRetryStopsOnLastAttempt(t *testing.T) {
+	defer func(r *retry.Retrier) { requestRetrier = r }(requestRetrier)
+
+	tests := []struct {
+		name     string
+		attempts int
+		statuses []int
+	}{
+		{"single attempt", 1, []int{404}},
+		{"after a server error", 2, []int{503, 404}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requestRetrier = retry.NewRetrier(tt.attempts, time.Millisecond, time.Millisecond)
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[calls])
+				calls++
+			}))
+			defer server.Close()
+
+			_, err := newEnsurer(Options{}).getWithRetry(context.Background(), server.URL)
+			if !isNotFound(err) {
+				t.Errorf("getWithRetry() error = %#v, want a not found error", err)
+			}
+		})
+	}
+}
+
+func TestGetWithRetryRateLimited(t *testing.T) {
+	defer func(r *retry.Retrier) { requestRetrier = r }(requestRetrier)
+	requestRetrier = retry.NewRetrier(3, time.Millisecond, time.Millisecond)
+
+	tests := []struct {
+		name       string
+		retryAfter []string
+		wantCalls  int
+		wantErr    string
+	}{
+		{"short wait", []string{"0"}, 2, ""},
+		{"long wait", []string{"3600"}, 1, "rate limited by 127.0.0.1"},
+		{"persistent", []string{"0", "0", "0"}, 3, "retry after 0 seconds"},
+		{"no Retry-After", []string{"", "", ""}, 3, "rate limited by"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls > len(tt.retryAfter) {
+					return
+				}
+				if after := tt.retryAfter[calls-1]; after != "" {
+					w.Header().Set("Retry-After", after)
+				}
+				http.Error(w, `{"error": "rate limited"}`, http.StatusTooManyRequests)
+			}))
+			defer server.Close()
+
+			resp, err := newEnsurer(Options{}).getWithRetry(context.Background(), server.URL)
+			if resp != nil {
+				resp.Body.Close()
+			}
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("getWithRetry() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("getWithRetry() error = %v, want %q", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("getWithRetry() made %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestComputeCandidates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[
+			{"attrs": {"subdir": "linux-64", "version": "4.9.0", "build_number": 0}, "download_url": "//example.com/pkg-4.9.0-0.tar.bz2"},
+			{"attrs": {"subdir": "linux-64", "version": "not a version", "build_number": 0}, "download_url": "//example.com/pkg.tar.bz2"},
+			{"attrs": {"subdir": "osx-64", "version": "4.10.0", "build_number": 0}, "download_url": "//example.com/pkg-4.10.0-0.tar.bz2"},
+			{"attrs": {"subdir": "linux-64", "version": "4.8.2", "build_number": 1}, "download_url": "/download/pkg-4.8.2-1.tar.bz2"},
+			{"attrs": {"subdir": "linux-64", "version": "4.8.2", "build_number": 0, "source_url": "https://example.com/pkg-4.8.2-0.tar.bz2"}},
+			{"attrs": {"subdir": "linux-64", "version": "4.11.0", "build_number": 0}, "download_url": "//example.com/pkg-4.11.0-0.conda"},
+			{"attrs": {"subdir": "linux-64", "version": "4.12.0", "build_number": 0, "source_url": "https://example.com/pkg-4.12.0.tar.gz"}}
+		]`)
+	}))
+	defer server.Close()
+
+	e := newEnsurer(Options{})
+	got, err := e.computeCandidates(context.Background(), server.URL, "linux-64")
+	if err != nil {
+		t.Fatalf("computeCandidates() error = %v", err)
+	}
+	var gotVersions, gotUrls []string
+	for _, c := range got {
+		gotVersions = append(gotVersions, fmt.Sprintf("%s-%d", c.Attrs.Version, c.Attrs.BuildNumber))
+		gotUrls = append(gotUrls, c.DownloadUrl)
+	}
+	want := []string{"4.8.2-0", "4.8.2-1", "4.9.0-0", "4.11.0-0"}
+	if fmt.Sprint(gotVersions) != fmt.Sprint(want) {
+		t.Errorf("computeCandidates() got = %v, want %v", gotVersions, want)
+	}
+	wantUrls := []string{
+		"https://example.com/pkg-4.8.2-0.tar.bz2",
+		server.URL + "/download/pkg-4.8.2-1.tar.bz2",
+		"http://example.com/pkg-4.9.0-0.tar.bz2",
+		"http://example.com/pkg-4.11.0-0.conda",
+	}
+	if fmt.Sprint(gotUrls) != fmt.Sprint(wantUrls) {
+		t.Errorf("computeCandidates() urls = %v, want %v", gotUrls, wantUrls)
+	}
+
+	_, err = e.computeCandidates(context.Background(), server.URL, "win-64")
+	if err != nil {
+		t.Errorf("computeCandidates() error = %v", err)
+	}
+}
+
+func TestCondaStandaloneCandidatesExcludeBuilds(t *testing.T) {
+	builds := []string{"h1234567_0", "h1234567_onedir_1", "onedir_h1234567_2", "h1234567onedir_3", "h1234567_ONEDIR_4", "h7654321_5"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pkgs []string
+		for i, build := range builds {
+			pkgs = append(pkgs, fmt.Sprintf(`{"attrs": {"subdir": %q, "version": "24.1.2", "build": %q, "build_number": %d}, "download_url": "/conda-standalone-24.1.2-%s.tar.bz2"}`,
+				PlatformSubdir(), build, i, build))
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(pkgs, ","))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		opts    Options
+		want    []string
+		wantErr bool
+	}{
+		{"onedir excluded by default", Options{}, []string{"h1234567_0", "h7654321_5"}, false},
+		{"allow onedir", Options{AllowOnedir: true}, builds, false},
+		{"custom pattern", Options{ExcludeBuilds: "^h7654321_"}, builds[:5], false},
+		{"everything excluded", Options{ExcludeBuilds: "."}, nil, true},
+		{"invalid pattern", Options{ExcludeBuilds: "("}, nil, true},
+		{"min build number", Options{AllowOnedir: true, CondaStandaloneMinBuildNumber: 4}, builds[4:], false},
+		{"min build number with exclusions", Options{CondaStandaloneMinBuildNumber: 1}, []string{"h7654321_5"}, false},
+		{"min build number too high", Options{CondaStandaloneMinBuildNumber: 6}, nil, true},
+		{"negative min build number", Options{CondaStandaloneMinBuildNumber: -1}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.CondaStandaloneChannel = server.URL
+			got, err := newEnsurer(tt.opts).condaStandaloneCandidates(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("condaStandaloneCandidates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var gotBuilds []string
+			for _, c := range got {
+				gotBuilds = append(gotBuilds, c.Attrs.Build)
+			}
+			if fmt.Sprint(gotBuilds) != fmt.Sprint(tt.want) {
+				t.Errorf("condaStandaloneCandidates() got = %v, want %v", gotBuilds, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstallCondaStandaloneNoCandidates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `[]`)
+	}))
+	defer server.Close()
+	os.Setenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL", server.URL)
+	defer os.Unsetenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+
+	_, err := InstallCondaStandalone(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), server.URL) || !strings.Contains(err.Error(), PlatformSubdir()) {
+		t.Errorf("InstallCondaStandalone() error = %v, want error naming the channel and subdir", err)
+	}
+}
+
+func TestDownloadAndUnpackArchiveErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.tar.bz2" {
+			http.Error(w, "no such package", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html>maintenance</html>")
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		path string
+		want []string
+	}{
+		{"not found", "/missing.tar.bz2", []string{"404", "no such package"}},
+		{"html error page", "/maintenance.tar.bz2", []string{"200", "maintenance"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newEnsurer(Options{}).downloadAndUnpackArchive(context.Background(), server.URL+tt.path, map[string]string{})
+			if err == nil {
+				t.Fatalf("downloadAndUnpackArchive() expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("downloadAndUnpackArchive() error = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDownloadCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := e.installMicromambaFrom(ctx, server.URL+"/latest")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("installMicromambaFrom() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	leftovers, _ := filepath.Glob(filepath.Join(opts.DataDir, "*.tmp"))
+	if len(leftovers) != 0 {
+		t.Errorf("installMicromambaFrom() left temporary files behind: %v", leftovers)
+	}
+}
+
+func TestInstallMicromambaConcurrently(t *testing.T) {
+	micromamba, err := ioutil.ReadFile(filepath.Join("testdata", "micromamba-latest.tar.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests, inFlight, maxInFlight int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write(micromamba)
+	}))
+	defer server.Close()
+	savedMicromamba := micromambaApiUrl
+	defer func() { micromambaApiUrl = savedMicromamba }()
+	micromambaApiUrl = server.URL
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	const installs = 4
+	errs := make(chan error, installs)
+	for i := 0; i < installs; i++ {
+		go func() {
+			_, err := InstallMicromamba(context.Background(), opts)
+			errs <- err
+		}()
+	}
+	for i := 0; i < installs; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("InstallMicromamba() error = %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&maxInFlight); got != 1 {
+		t.Errorf("concurrent InstallMicromamba() downloaded %d times at once, want 1", got)
+	}
+	// the installs waiting for the lock use the micromamba installed meanwhile
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("concurrent InstallMicromamba() downloaded %d times, want 1", got)
+	}
+	exe := newEnsurer(opts).targetExeFilename("micromamba")
+	if _, ok, err := newEnsurer(opts).flavorCheck(Micromamba)(context.Background(), exe); !ok || err != nil {
+		t.Errorf("micromamba installed concurrently doesn't work: %v", err)
+	}
+}
+
+func TestAcquireLockInterrupted(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+	held := newTestLock(t, e, "conda_exe_install")
+	if err := e.acquireLock(context.Background(), held); err != nil {
+		t.Fatal(err)
+	}
+	defer releaseLock(held)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err := e.acquireLock(ctx, newTestLock(t, e, "conda_exe_install"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("acquireLock() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWriteFileLockCancelled(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.FileLockMaxDelay = time.Minute
+	e := newEnsurer(opts)
+	target := filepath.Join(opts.DataDir, "micromamba")
+	held := newTestLock(t, e, filepath.Base(target))
+	if locked, err := held.TryLock(); !locked || err != nil {
+		t.Fatalf("TryLock() = %v, %v", locked, err)
+	}
+	defer held.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	err := e.writeFile(ctx, target, strings.NewReader("micromamba"), -1)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("writeFile() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("writeFile() waited %v for the file lock after being cancelled", elapsed)
+	}
+}
+
+func TestWriteFileLockRetries(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.FileLockRetries = 3
+	opts.FileLockMaxDelay = time.Millisecond
+	e := newEnsurer(opts)
+	target := filepath.Join(opts.DataDir, "micromamba")
+	held := newTestLock(t, e, filepath.Base(target))
+	if locked, err := held.TryLock(); !locked || err != nil {
+		t.Fatalf("TryLock() = %v, %v", locked, err)
+	}
+
+	err := e.writeFile(context.Background(), target, strings.NewReader("micromamba"), -1)
+	if err == nil || !strings.Contains(err.Error(), "could not lock") {
+		t.Errorf("writeFile() error = %v, want the file lock reported as held", err)
+	}
+	held.Unlock()
+	if err := e.writeFile(context.Background(), target, strings.NewReader("micromamba"), -1); err != nil {
+		t.Errorf("writeFile() error = %v once the file lock is released", err)
+	}
+
+	// the error of a single try is returned as is, e.g. for truncated downloads to be retried
+	opts.FileLockRetries = 1
+	src, w := io.Pipe()
+	go func() {
+		w.Write([]byte("micro"))
+		w.CloseWithError(errArchiveTruncated)
+	}()
+	if err := newEnsurer(opts).writeFile(context.Background(), target, src, -1); !errors.Is(err, errArchiveTruncated) {
+		t.Errorf("writeFile() error = %v, want %v", err, errArchiveTruncated)
+	}
+
+	defer os.Setenv("ENSURECONDA_FILE_LOCK_RETRIES", os.Getenv("ENSURECONDA_FILE_LOCK_RETRIES"))
+	os.Setenv("ENSURECONDA_FILE_LOCK_RETRIES", "many")
+	opts.FileLockRetries = 0
+	if _, err := newEnsurer(opts).fileLockRetrier(); err == nil {
+		t.Errorf("fileLockRetrier() with ENSURECONDA_FILE_LOCK_RETRIES=many expected an error")
+	}
+}
+
+// makeTarball builds an uncompressed tarball holding the given files.
+func makeTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range files {
+		hdr := &tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// makeTarZst builds a zstd compressed tarball holding files.
+func makeTarZst(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(makeTarball(t, files)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// makeCondaPackage builds a .conda archive whose pkg-<name>-*.tar.zst member holds files.
+func makeCondaPackage(t *testing.T, name string, files map[string]string) []byte {
+	pkg := makeTarZst(t, files)
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	members := []struct {
+		name    string
+		content []byte
+	}{
+		{"metadata.json", []byte(`{"conda_pkg_format_version": 2}`)},
+		{fmt.Sprintf("info-%s-1.0-0.tar.zst", name), []byte("not a tarball")},
+		{fmt.Sprintf("pkg-%s-1.0-0.tar.zst", name), pkg},
+	}
+	for _, member := range members {
+		w, err := archive.Create(member.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(member.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestInstallMicromambaFromConda(t *testing.T) {
+	packages := map[string][]byte{
+		"/micromamba-1.0-0.conda":       makeCondaPackage(t, "micromamba", map[string]string{"bin/micromamba": "#!/bin/sh\necho 1.0\n"}),
+		"/micromamba-1.0-0.conda?x=1":   makeCondaPackage(t, "micromamba", map[string]string{"Library/bin/micromamba.exe": "1.0"}),
+		"/conda-standalone-1.0-0.conda": makeCondaPackage(t, "conda-standalone", map[string]string{"standalone_conda/conda.exe": "1.0"}),
+		"/micromamba-2.0-0.conda":       makeCondaPackage(t, "mamba-static", map[string]string{"bin/micromamba": "2.0"}),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(packages[r.URL.RequestURI()])
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"unix layout", "/micromamba-1.0-0.conda", "#!/bin/sh\necho 1.0\n", false},
+		{"windows layout with query string", "/micromamba-1.0-0.conda?x=1", "1.0", false},
+		{"differently named inner package", "/micromamba-2.0-0.conda", "2.0", false},
+		{"missing executable", "/conda-standalone-1.0-0.conda", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+
+			got, err := newEnsurer(opts).installMicromambaFrom(context.Background(), server.URL+tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("installMicromambaFrom() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := filepath.Join(opts.DataDir, "micromamba"+pathExt); got != want {
+				t.Errorf("installMicromambaFrom() got = %v, want %v", got, want)
+			}
+			if content, _ := ioutil.ReadFile(got); string(content) != tt.want {
+				t.Errorf("installMicromambaFrom() extracted %q, want %q", content, tt.want)
+			}
+			if leftovers, _ := filepath.Glob(filepath.Join(opts.DataDir, "*.conda")); len(leftovers) != 0 {
+				t.Errorf("installMicromambaFrom() left downloads in the data directory: %v", leftovers)
+			}
+		})
+	}
+}
+
+func TestDownloadRetriesTruncatedArchives(t *testing.T) {
+	defer func(r *retry.Retrier) { downloadRetrier = r }(downloadRetrier)
+	downloadRetrier = retry.NewRetrier(3, time.Millisecond, time.Millisecond)
+	conda := makeCondaPackage(t, "micromamba", map[string]string{"bin/micromamba": "2.0", "Library/bin/micromamba.exe": "2.0"})
+	tarZst := makeTarZst(t, map[string]string{"bin/micromamba": strings.Repeat("2.0", 1000), "Library/bin/micromamba.exe": "2.0"})
+	tarball, err := ioutil.ReadFile(filepath.Join("testdata", "micromamba-latest.tar.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		archive      []byte
+		truncated    int32
+		wantRequests int32
+		wantErr      bool
+	}{
+		{"conda truncated once", "/micromamba.conda", conda, 1, 2, false},
+		{"tar.bz2 truncated once", "/micromamba.tar.bz2", tarball, 1, 2, false},
+		{"tar.zst", "/micromamba.tar.zst", tarZst, 0, 1, false},
+		{"tar.zst truncated once", "/micromamba.tar.zst", tarZst, 1, 2, false},
+		{"conda always truncated", "/micromamba.conda", conda, 3, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) <= tt.truncated {
+					w.Write(tt.archive[:len(tt.archive)/2])
+					return
+				}
+				w.Write(tt.archive)
+			}))
+			defer server.Close()
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+
+			_, err := newEnsurer(opts).installMicromambaFrom(context.Background(), server.URL+tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("installMicromambaFrom() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, errArchiveTruncated) {
+				t.Errorf("installMicromambaFrom() error = %v, want %v", err, errArchiveTruncated)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("installMicromambaFrom() made %d requests, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestInstallMicromambaFollowsRedirects(t *testing.T) {
+	tarball, err := ioutil.ReadFile(filepath.Join("testdata", "micromamba-latest.tar.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conda := makeCondaPackage(t, "micromamba", map[string]string{"bin/micromamba": "2.0", "Library/bin/micromamba.exe": "2.0"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/conda/latest":
+			http.Redirect(w, r, "/artifacts/micromamba-2.0-0.conda", http.StatusFound)
+		case "/blob/latest":
+			http.Redirect(w, r, "/artifacts/0123456789abcdef", http.StatusFound)
+		case "/artifacts/micromamba-2.0-0.conda":
+			// the extension of the final url is trusted over a wrong content type
+			w.Header().Set("Content-Type", "application/x-bzip2")
+			w.Write(conda)
+		case "/artifacts/0123456789abcdef":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(tarball)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/conda/latest", "2.0"},
+		{"/blob/latest", "1.5.8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+
+			got, err := newEnsurer(opts).installMicromambaFrom(context.Background(), server.URL+tt.path)
+			if err != nil {
+				t.Fatalf("installMicromambaFrom() error = %v", err)
+			}
+			if content, _ := ioutil.ReadFile(got); !strings.Contains(string(content), tt.want) {
+				t.Errorf("installMicromambaFrom() extracted %q, want micromamba %s", content, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeepArchive(t *testing.T) {
+	pkg := makeCondaPackage(t, "micromamba", map[string]string{"bin/micromamba": "1.0"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/download/micromamba.conda" {
+			http.Redirect(w, r, "/pkgs/micromamba-1.0-0.conda", http.StatusFound)
+			return
+		}
+		w.Write(pkg)
+	}))
+	defer server.Close()
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.KeepArchiveDir = filepath.Join(opts.DataDir, "archives")
+
+	if _, err := newEnsurer(opts).installMicromambaFrom(context.Background(), server.URL+"/download/micromamba.conda"); err != nil {
+		t.Fatalf("installMicromambaFrom() error = %v", err)
+	}
+	kept, err := ioutil.ReadFile(filepath.Join(opts.KeepArchiveDir, "micromamba-1.0-0.conda"))
+	if err != nil {
+		t.Fatalf("installMicromambaFrom() didn't keep the archive: %v", err)
+	}
+	if !bytes.Equal(kept, pkg) {
+		t.Errorf("installMicromambaFrom() kept %d bytes, want the %d byte archive", len(kept), len(pkg))
+	}
+}
+
+func TestIsCondaPkgMember(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"pkg-conda-standalone-24.1.2-h_onedir_0.tar.zst", true},
+		{"pkg-micromamba-1.5.8-0.tar.zst", true},
+		{"info-micromamba-1.5.8-0.tar.zst", false},
+		{"metadata.json", false},
+		{"pkg-micromamba-1.5.8-0.tar.bz2", false},
+		{"nested/pkg-micromamba-1.5.8-0.tar.zst", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isCondaPkgMember(tt.name); got != tt.want {
+				t.Errorf("isCondaPkgMember() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInferArchiveType(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		finalUrl    string
+		contentType string
+		body        string
+		want        string
+		wantErr     bool
+	}{
+		{"tar.bz2", "https://example.com/micromamba-1.5.8-0.tar.bz2", "", "", "", archiveTarBz2, false},
+		{"conda", "https://example.com/conda-standalone-24.1.2-0.conda", "", "", "", archiveConda, false},
+		{"query string", "https://mirror.example.com/micromamba-1.5.8-0.tar.bz2?token=x", "", "", "", archiveTarBz2, false},
+		{"uppercase extension", "https://example.com/CONDA-STANDALONE-24.1.2-0.CONDA", "", "", "", archiveConda, false},
+		{"extension beats content type", "https://example.com/pkg.conda", "", "application/x-bzip2", "", archiveConda, false},
+		{"latest by content type", "https://example.com/linux-64/latest", "", "application/x-bzip2", "", archiveTarBz2, false},
+		{"zip content type", "https://example.com/linux-64/latest", "", "application/zip; charset=binary", "", archiveConda, false},
+		{"latest by magic bytes", "https://example.com/linux-64/latest", "", "application/octet-stream", "BZh91AY&SY", archiveTarBz2, false},
+		{"zip magic bytes", "https://example.com/download?id=1", "", "", "PK\x03\x04rest", archiveConda, false},
+		{"tar.zst", "https://example.com/micromamba-2.0.5-0.tar.zst", "", "", "", archiveTarZst, false},
+		{"zstd content type", "https://example.com/linux-64/latest", "", "application/zstd", "", archiveTarZst, false},
+		{"zstd magic bytes", "https://example.com/linux-64/latest", "", "", "\x28\xb5\x2f\xfdrest", archiveTarZst, false},
+		{"unknown", "https://example.com/micromamba.zip", "", "application/octet-stream", "MZ\x90\x00", "", true},
+		{"redirected to conda", "https://example.com/linux-64/latest", "https://cdn.example.com/micromamba-1.5.8-0.conda", "application/x-bzip2", "", archiveConda, false},
+		{"redirect without extension", "https://example.com/pkg.tar.bz2", "https://cdn.example.com/blob/1234", "", "", archiveTarBz2, false},
+		{"empty", "https://example.com/linux-64/latest", "", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finalUrl := tt.finalUrl
+			if finalUrl == "" {
+				finalUrl = tt.url
+			}
+			got, err := inferArchiveType(tt.url, finalUrl, tt.contentType, bufio.NewReader(strings.NewReader(tt.body)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("inferArchiveType() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.url) {
+				t.Errorf("inferArchiveType() error = %v, want it to name the url", err)
+			}
+			if got != tt.want {
+				t.Errorf("inferArchiveType() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractTarFilesCleansUpOnFailure(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+
+	tarball := makeTarball(t, map[string]string{"bin/micromamba": strings.Repeat("x", 4096)})
+	// cut the archive off halfway through the file contents
+	truncated := bytes.NewReader(tarball[:512+2048])
+	target := e.targetExeFilename("micromamba")
+
+	_, err := e.extractTarFiles(context.Background(), tar.NewReader(truncated), map[string]string{"bin/micromamba": target})
+	if err == nil {
+		t.Fatal("extractTarFiles() expected an error for a truncated archive")
+	}
+	// The lock file stays in the lock directory: another process may already be waiting on it.
+	for _, leftover := range []string{target, target + ".tmp"} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Errorf("extractTarFiles() left %s behind", leftover)
+		}
+	}
+
+	got, err := e.extractTarFiles(context.Background(), tar.NewReader(bytes.NewReader(tarball)), map[string]string{"bin/micromamba": target})
+	if err != nil || got != target {
+		t.Errorf("extractTarFiles() = %v, %v, want %v", got, err, target)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(opts.DataDir, "*.lock")); len(leftovers) != 0 {
+		t.Errorf("extractTarFiles() left lock files in the data directory: %v", leftovers)
+	}
+}
+
+func TestExtractTarFilesErrors(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+
+	other := makeTarball(t, map[string]string{"info/index.json": strings.Repeat("x", 4096)})
+	tests := []struct {
+		name          string
+		tarball       []byte
+		wantTruncated bool
+		want          string
+	}{
+		{"truncated in the wanted file", makeTarball(t, map[string]string{"bin/micromamba": strings.Repeat("x", 4096)})[:512+2048], true, "truncated"},
+		{"truncated before the wanted file", other[:512+2048], true, "truncated"},
+		{"corrupt header", bytes.Repeat([]byte{0xff}, 1024), false, "corrupt"},
+		{"wanted file absent", other, false, "bin/micromamba"},
+		{"empty file", makeTarball(t, map[string]string{"bin/micromamba": ""}), false, "empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := e.extractTarFiles(context.Background(), tar.NewReader(bytes.NewReader(tt.tarball)), map[string]string{"bin/micromamba": e.targetExeFilename("micromamba")})
+			if err == nil {
+				t.Fatal("extractTarFiles() expected an error")
+			}
+			if errors.Is(err, errArchiveTruncated) != tt.wantTruncated {
+				t.Errorf("extractTarFiles() error = %v, want truncated %v", err, tt.wantTruncated)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("extractTarFiles() error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractTarFilesLinks(t *testing.T) {
+	dir := &tar.Header{Name: "./bin/", Typeflag: tar.TypeDir, Mode: 0755}
+	file := &tar.Header{Name: "./bin/micromamba-1.5.8", Typeflag: tar.TypeReg, Mode: 0755, Size: 4}
+	symlink := &tar.Header{Name: "./bin/micromamba", Typeflag: tar.TypeSymlink, Linkname: "micromamba-1.5.8"}
+	hardlink := &tar.Header{Name: "bin/micromamba", Typeflag: tar.TypeLink, Linkname: "bin/micromamba-1.5.8"}
+	chained := &tar.Header{Name: "bin/mm", Typeflag: tar.TypeSymlink, Linkname: "micromamba-1.5.8"}
+	toChained := &tar.Header{Name: "bin/micromamba", Typeflag: tar.TypeSymlink, Linkname: "mm"}
+	other := &tar.Header{Name: "info/index.json", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}
+	absolute := &tar.Header{Name: "bin/micromamba", Typeflag: tar.TypeSymlink, Linkname: "/usr/bin/micromamba"}
+
+	tests := []struct {
+		name    string
+		headers []*tar.Header
+		wantErr bool
+	}{
+		{"symlink before file", []*tar.Header{dir, symlink, other, file}, false},
+		{"symlink after file", []*tar.Header{dir, file, other, symlink}, false},
+		{"hard link", []*tar.Header{file, hardlink}, false},
+		{"chained symlinks", []*tar.Header{file, chained, toChained}, false},
+		{"dangling symlink", []*tar.Header{dir, symlink, other}, true},
+		{"absolute symlink", []*tar.Header{file, absolute}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			e := newEnsurer(opts)
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, hdr := range tt.headers {
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatal(err)
+				}
+				if hdr.Size > 0 {
+					tw.Write([]byte("real"))
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			target := e.targetExeFilename("micromamba")
+			got, err := e.extractTarFiles(context.Background(), tar.NewReader(&buf), map[string]string{"bin/micromamba": target})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractTarFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if content, _ := ioutil.ReadFile(got); string(content) != "real" {
+					t.Errorf("extractTarFiles() extracted %q, want the linked file", content)
+				}
+			}
+			if leftovers, _ := filepath.Glob(filepath.Join(opts.DataDir, "extract-*")); len(leftovers) != 0 {
+				t.Errorf("extractTarFiles() left spooled files behind: %v", leftovers)
+			}
+		})
+	}
+}
+
+func TestCondaStandaloneLayouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		member  string
+		wantErr bool
+	}{
+		{"standalone_conda", "standalone_conda/conda.exe", false},
+		{"without .exe", "standalone_conda/conda", false},
+		{"nested", "opt/standalone_conda/conda.exe", false},
+		{"dot prefixed", "./standalone_conda/conda.exe", false},
+		{"onedir", "standalone_conda/_conda.exe", true},
+		{"elsewhere", "bin/conda.exe", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			e := newEnsurer(opts)
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, name := range []string{"info/index.json", tt.member} {
+				if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0755, Size: 4}); err != nil {
+					t.Fatal(err)
+				}
+				tw.Write([]byte("real"))
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			target := e.targetExeFilename("conda_standalone")
+			got, err := e.extractTarFiles(context.Background(), tar.NewReader(&buf), condaStandaloneFileNameMap(target))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractTarFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != target {
+				t.Errorf("extractTarFiles() got = %v, want %v", got, target)
+			}
+		})
+	}
+}
+
+func TestMicromambaLayouts(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+		want    string
+		wantErr bool
+	}{
+		{"bin", []string{"bin/micromamba"}, "bin/micromamba", false},
+		{"unexpected directory", []string{"opt/micromamba/bin/micromamba"}, "opt/micromamba/bin/micromamba", false},
+		{"windows elsewhere", []string{"Scripts/micromamba.exe"}, "Scripts/micromamba.exe", false},
+		{"expected path preferred", []string{"share/micromamba", "bin/micromamba"}, "bin/micromamba", false},
+		{"first unexpected path", []string{"libexec/micromamba", "share/micromamba"}, "libexec/micromamba", false},
+		{"package metadata", []string{"info/recipe/micromamba"}, "", true},
+		{"missing", []string{"bin/mamba"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			e := newEnsurer(opts)
+
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, name := range append([]string{"info/index.json"}, tt.members...) {
+				if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(name))}); err != nil {
+					t.Fatal(err)
+				}
+				tw.Write([]byte(name))
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			target := e.targetExeFilename("micromamba")
+			got, err := e.extractTarFiles(context.Background(), tar.NewReader(&buf), micromambaFileNameMap(target))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("extractTarFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != target {
+				t.Errorf("extractTarFiles() got = %v, want %v", got, target)
+			}
+			if content, _ := ioutil.ReadFile(target); string(content) != tt.want {
+				t.Errorf("extractTarFiles() extracted %q, want %q", content, tt.want)
+			}
+			if leftovers, _ := filepath.Glob(filepath.Join(opts.DataDir, "extract-*")); len(leftovers) > 0 {
+				t.Errorf("extractTarFiles() left spooled files behind: %v", leftovers)
+			}
+		})
+	}
+}
+
+func TestWriteFileReplacesExecutable(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+	target := e.targetExeFilename("micromamba")
+	if err := ioutil.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.writeFile(context.Background(), target, strings.NewReader("new"), 3); err != nil {
+		t.Fatalf("writeFile() error = %v", err)
+	}
+	if content, _ := ioutil.ReadFile(target); string(content) != "new" {
+		t.Errorf("writeFile() wrote %q, want %q", content, "new")
+	}
+	if runtime.GOOS != "windows" {
+		st, err := os.Stat(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode().Perm() != executablePerm {
+			t.Errorf("writeFile() left mode %v, want %v", st.Mode().Perm(), executablePerm)
+		}
+	}
+	if leftovers, _ := filepath.Glob(target + ".*"); len(leftovers) != 0 {
+		t.Errorf("writeFile() left files behind: %v", leftovers)
+	}
+}
+
+func TestDataDirInstallMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not kept on windows")
+	}
+	tests := []struct {
+		mode os.FileMode
+		want os.FileMode
+	}{
+		{0, 0755},
+		{0700, 0700},
+		{0750, 0750},
+		{0775, 0775},
+		{0744, 0755},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			opts.DataDir = filepath.Join(opts.DataDir, "shared")
+			opts.InstallMode = tt.mode
+			e := newEnsurer(opts)
+			if err := e.prepareDataDir(); err != nil {
+				t.Fatal(err)
+			}
+			lockPath, err := e.lockPath("micromamba")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, dir := range []string{opts.DataDir, filepath.Dir(lockPath)} {
+				st, err := os.Stat(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if st.Mode().Perm() != tt.want {
+					t.Errorf("%s created with mode %v, want %v", dir, st.Mode().Perm(), tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteFileInstallMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permission bits are not kept on windows")
+	}
+	tests := []struct {
+		name    string
+		mode    os.FileMode
+		want    os.FileMode
+		wantErr bool
+	}{
+		{"default", 0, executablePerm, false},
+		{"group writable", 0775, 0775, false},
+		{"owner only", 0700, 0700, false},
+		{"not executable", 0644, 0, true},
+		{"not permission bits", 01755, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			opts.InstallMode = tt.mode
+			e := newEnsurer(opts)
+			target := e.targetExeFilename("micromamba")
+
+			err := e.writeFile(context.Background(), target, strings.NewReader("new"), 3)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if err := e.prepareDataDir(); err == nil {
+					t.Errorf("prepareDataDir() accepted install mode %v", tt.mode)
+				}
+				return
+			}
+			st, err := os.Stat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if st.Mode().Perm() != tt.want {
+				t.Errorf("writeFile() left mode %v, want %v", st.Mode().Perm(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteFileSetsModTime(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+	target := writeFakeConda(t, opts.DataDir, "conda_standalone", "23.1.0")
+	installedLongAgo := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(target, installedLongAgo, installedLongAgo); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.versionOutput(context.Background(), target); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reinstall of the same size must not be mistaken for the cached executable
+	start := time.Now().Add(-time.Second)
+	if err := e.writeFile(context.Background(), target, strings.NewReader("#!/bin/sh\necho conda 24.3.0\n"), -1); err != nil {
+		t.Fatalf("writeFile() error = %v", err)
+	}
+	st, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.ModTime().Before(start) {
+		t.Errorf("writeFile() left mtime %v, want the install time", st.ModTime())
+	}
+	if stdout, _ := e.versionOutput(context.Background(), target); strings.TrimSpace(string(stdout)) != "conda 24.3.0" {
+		t.Errorf("versionOutput() got = %q after a reinstall, want conda 24.3.0", stdout)
+	}
+}
+
+func TestResolveDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"attrs": {"subdir": %q, "version": "4.10.3", "build_number": 2, "source_url": "https://example.com/conda-standalone.tar.bz2"}}]`,
+			PlatformSubdir())
+	}))
+	defer server.Close()
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.CondaStandalone = true
+	opts.DryRun = true
+	opts.CondaStandaloneChannel = server.URL
+
+	got, err := Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	want := PlannedInstall{
+		Flavor:      CondaStandalone,
+		Url:         "https://example.com/conda-standalone.tar.bz2",
+		Version:     "4.10.3",
+		BuildNumber: 2,
+	}
+	if got.Executable != "" || got.Planned == nil || *got.Planned != want {
+		t.Errorf("Resolve() got = %+v, want planned %+v", got, want)
+	}
+	if files, _ := ioutil.ReadDir(opts.DataDir); len(files) != 0 {
+		t.Errorf("Resolve() wrote to the data directory during a dry run")
+	}
+}
+
+func TestResolveDryRunMicromambaChannel(t *testing.T) {
+	var requested string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Path
+		fmt.Fprintf(w, `[
+			{"attrs": {"subdir": %[1]q, "version": "1.5.8", "build_number": 0, "source_url": "https://github.com/mamba-org/mamba/archive/1.5.8.tar.gz"}, "download_url": "//example.com/micromamba-1.5.8-0.tar.bz2"},
+			{"attrs": {"subdir": %[1]q, "version": "1.5.10", "build_number": 0, "source_url": "https://github.com/mamba-org/mamba/archive/1.5.10.tar.gz"}, "download_url": "//example.com/micromamba-1.5.10-0.tar.bz2"}
+		]`, PlatformSubdir())
+	}))
+	defer server.Close()
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.Micromamba = true
+	opts.DryRun = true
+	opts.MicromambaChannel = server.URL + "/conda-forge"
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+
+	got, err := Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if requested != "/conda-forge/micromamba/files" {
+		t.Errorf("Resolve() listed %s, want /conda-forge/micromamba/files", requested)
+	}
+	want := PlannedInstall{
+		Flavor:  Micromamba,
+		Url:     "http://example.com/micromamba-1.5.10-0.tar.bz2",
+		Version: "1.5.10",
+	}
+	if got.Planned == nil || *got.Planned != want {
+		t.Errorf("Resolve() got = %+v, want planned %+v", got, want)
+	}
+}
+
+func TestResolveForeignPlatform(t *testing.T) {
+	foreign := "linux-aarch64"
+	if PlatformSubdir() == foreign {
+		foreign = "osx-64"
+	}
+	pkg := makeCondaPackage(t, "micromamba", map[string]string{"bin/micromamba": "not runnable here"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".conda") {
+			w.Write(pkg)
+			return
+		}
+		fmt.Fprintf(w, `[
+			{"attrs": {"subdir": %q, "version": "1.5.10", "build_number": 0}, "download_url": "/micromamba-1.5.10-0.conda"},
+			{"attrs": {"subdir": %q, "version": "1.5.8", "build_number": 0}, "download_url": "/wrong-platform.conda"}
+		]`, foreign, PlatformSubdir())
+	}))
+	defer server.Close()
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.Micromamba = true
+	opts.MicromambaChannel = server.URL + "/conda-forge"
+	opts.Platform = foreign
+
+	got, err := Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if want := filepath.Join(opts.DataDir, "micromamba"); got.Executable != want || got.Version != nil {
+		t.Errorf("Resolve() got = %+v, want %s without a version", got, want)
+	}
+	if content, _ := ioutil.ReadFile(got.Executable); string(content) != "not runnable here" {
+		t.Errorf("Resolve() installed %q, want the %s package", content, foreign)
+	}
+
+	if got, want := newEnsurer(Options{Platform: foreign}).dataDir, filepath.Join(DefaultDataDir(), foreign); got != want {
+		t.Errorf("newEnsurer() data dir = %v, want %v", got, want)
+	}
+	opts.Platform = "linux-s390x"
+	if _, err := Resolve(context.Background(), opts); err == nil {
+		t.Errorf("Resolve() expected an error for an unsupported platform")
+	}
+}
+
+func TestResolveForceInstall(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	exe := writeFakeConda(t, opts.DataDir, "conda_standalone", "4.10.3")
+
+	pkg := makeCondaPackage(t, "conda-standalone", map[string]string{
+		"standalone_conda/conda.exe": "#!/bin/sh\necho conda 4.11.0\n",
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".conda") {
+			w.Write(pkg)
+			return
+		}
+		fmt.Fprintf(w, `[{"attrs": {"subdir": %q, "version": "4.11.0", "build_number": 0}, "download_url": "/conda-standalone-4.11.0-0.conda"}]`,
+			PlatformSubdir())
+	}))
+	defer server.Close()
+
+	opts.CondaStandalone = true
+	opts.CondaStandaloneChannel = server.URL
+	opts.ForceInstall = true
+
+	got, err := Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got.Executable != exe || got.Version.String() != "4.11.0" {
+		t.Errorf("Resolve() got = %+v, want a freshly installed %s 4.11.0", got, exe)
+	}
+
+	opts.NoInstall = true
+	if _, err := Resolve(context.Background(), opts); err == nil {
+		t.Errorf("Resolve() expected an error combining ForceInstall and NoInstall")
+	}
+}
+
+func TestInstallUnwritableDataDir(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	// a data directory below a regular file can't be created, even by root
+	file := filepath.Join(opts.DataDir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts.DataDir = filepath.Join(file, "data")
+	opts.MicromambaChannel = "http://channel.ensureconda.test/conda-forge"
+
+	_, err := InstallMicromamba(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "data directory is not writable: "+opts.DataDir) {
+		t.Errorf("InstallMicromamba() error = %v, want the data directory reported as not writable", err)
+	}
+	_, err = InstallCondaStandalone(context.Background(), opts)
+	if err == nil || !strings.Contains(err.Error(), "data directory is not writable: "+opts.DataDir) {
+		t.Errorf("InstallCondaStandalone() error = %v, want the data directory reported as not writable", err)
+	}
+}
+
+func TestInstallUnsupportedPlatform(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.CondaStandaloneChannel = server.URL
+	opts.MicromambaChannel = server.URL
+	// as on a host PlatformSubdir() doesn't know
+	unsupported := func() *ensurer {
+		e := newEnsurer(opts)
+		e.subdir = ""
+		return e
+	}
+
+	_, err := unsupported().installMicromamba(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unsupported platform") {
+		t.Errorf("installMicromamba() error = %v, want an unsupported platform", err)
+	}
+	_, _, err = unsupported().installCondaStandalone(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unsupported platform") {
+		t.Errorf("installCondaStandalone() error = %v, want an unsupported platform", err)
+	}
+	opts.DryRun = true
+	opts.Micromamba = true
+	_, err = unsupported().ensure(context.Background(), true)
+	if err == nil || !strings.Contains(err.Error(), "unsupported platform") {
+		t.Errorf("ensure() error = %v, want an unsupported platform", err)
+	}
+	if requests != 0 {
+		t.Errorf("made %d requests for an unsupported platform, want none", requests)
+	}
+}
+
+func TestInstallMicromambaNoBuild(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	defer func(u, g string) { micromambaApiUrl, micromambaGithubUrl = u, g }(micromambaApiUrl, micromambaGithubUrl)
+	micromambaApiUrl = server.URL
+	micromambaGithubUrl = server.URL
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.Platform = "linux-riscv64"
+	_, err := InstallMicromamba(context.Background(), opts)
+	if want := "no micromamba build available for linux-riscv64"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("InstallMicromamba() error = %v, want it to contain %q", err, want)
+	}
+}
+
+func TestChannelNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "\"no-such-channel\" not found"}`, http.StatusNotFound)
+	}))
+	defer server.Close()
+	defer func(u string) { anacondaApiUrl = u }(anacondaApiUrl)
+	anacondaApiUrl = server.URL
+	_, restore := isolateCondarc(t)
+	defer restore()
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.CondaStandaloneChannel = "no-such-channel"
+	_, err := newEnsurer(opts).channelCondaStandaloneCandidates(context.Background())
+	if want := "channel no-such-channel not found or has no conda-standalone package"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("channelCondaStandaloneCandidates() error = %v, want it to contain %q", err, want)
+	}
+	if !isNotFound(err) {
+		t.Errorf("channelCondaStandaloneCandidates() error = %v, want it to wrap the 404", err)
+	}
+}
+
+func TestInstallMicromambaGithubFallback(t *testing.T) {
+	micromamba, err := ioutil.ReadFile(filepath.Join("testdata", "micromamba-latest.tar.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path == "/github/micromamba-"+PlatformSubdir()+".tar.bz2" {
+			w.Write(micromamba)
+			return
+		}
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	defer func(u, g string) { micromambaApiUrl, micromambaGithubUrl = u, g }(micromambaApiUrl, micromambaGithubUrl)
+	micromambaApiUrl = server.URL + "/micromamba"
+	micromambaGithubUrl = server.URL + "/github"
+	defer func(r *retry.Retrier) { requestRetrier = r }(requestRetrier)
+	requestRetrier = retry.NewRetrier(1, time.Millisecond, time.Millisecond)
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	exe, err := InstallMicromamba(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("InstallMicromamba() error = %v", err)
+	}
+	want := []string{"/micromamba/" + PlatformSubdir() + "/latest", "/github/micromamba-" + PlatformSubdir() + ".tar.bz2"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("InstallMicromamba() requested %v, want %v", requests, want)
+	}
+	if metadata, err := ReadMetadata(exe); err != nil || metadata.Url != server.URL+want[1] {
+		t.Errorf("ReadMetadata() got = %+v, %v, want it installed from GitHub", metadata, err)
+	}
+}
+
+func TestInstallMicromambaFromFile(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	savedMicromamba, savedGithub := micromambaApiUrl, micromambaGithubUrl
+	defer func() { micromambaApiUrl, micromambaGithubUrl = savedMicromamba, savedGithub }()
+	micromambaApiUrl, micromambaGithubUrl = server.URL, server.URL
+	archive, err := filepath.Abs(filepath.Join("testdata", "micromamba-latest.tar.bz2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileUrl := (&url.URL{Scheme: "file", Path: filepath.ToSlash(archive)}).String()
+	if !strings.HasPrefix(filepath.ToSlash(archive), "/") {
+		fileUrl = "file:///" + filepath.ToSlash(archive)
+	}
+
+	tests := []struct {
+		name    string
+		mirror  string
+		wantErr bool
+	}{
+		{"file url", fileUrl, false},
+		{"absolute path", archive, false},
+		{"missing file", archive + ".missing", true},
+		{"directory", filepath.Dir(archive), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			opts.MicromambaMirrors = []string{tt.mirror}
+			opts.Offline = true
+
+			got, err := InstallMicromamba(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InstallMicromamba() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if _, err := os.Stat(got); err != nil {
+					t.Errorf("InstallMicromamba() = %v, which doesn't exist: %v", got, err)
+				}
+			}
+		})
+	}
+	if got := atomic.LoadInt32(&requests); got != 0 {
+		t.Errorf("installing from files made %d requests", got)
+	}
+}
+
+func TestInstallMicromambaMirrors(t *testing.T) {
+	defer serveFixtures(t, "24.3.0")()
+	var requests []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		http.Error(w, "mirror down", http.StatusBadGateway)
+	}))
+	defer mirror.Close()
+	defer func(r *retry.Retrier) { requestRetrier = r }(requestRetrier)
+	requestRetrier = retry.NewRetrier(1, time.Millisecond, time.Millisecond)
+
+	tests := []struct {
+		name         string
+		mirrors      []string
+		wantRequests []string
+		wantErr      bool
+	}{
+		{"no mirrors", nil, nil, false},
+		{"failing mirror", []string{mirror.URL + "/mm/{subdir}/latest"}, []string{"/mm/" + PlatformSubdir() + "/latest"}, false},
+		{"invalid mirror", []string{"mirror.example.com/{subdir}"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = nil
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			opts.MicromambaMirrors = tt.mirrors
+
+			_, err := InstallMicromamba(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InstallMicromamba() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(requests) != fmt.Sprint(tt.wantRequests) {
+				t.Errorf("InstallMicromamba() requested %v from the mirror, want %v", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestLogChosen(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New()
+	logger.Out = &logs
+	logger.Formatter = &log.JSONFormatter{}
+	e := newEnsurer(Options{Logger: logger})
+
+	e.logChosen("conda-standalone", AnacondaPkg{
+		Attrs:       AnacondaPkgAttr{Version: "24.1.2", Build: "h_0", BuildNumber: 3, Timestamp: 1706745600000},
+		DownloadUrl: "https://conda.anaconda.org/anaconda/osx-arm64/conda-standalone-24.1.2-h_0.conda",
+	})
+	for _, want := range []string{
+		`"version":"24.1.2"`,
+		`"build":"h_0"`,
+		`"buildNumber":3`,
+		`"timestamp":"2024-02-01T00:00:00Z"`,
+		`"url":"https://conda.anaconda.org/anaconda/osx-arm64/conda-standalone-24.1.2-h_0.conda"`,
+		`"level":"info"`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logChosen() logged %s, want %s", logs.String(), want)
+		}
+	}
+}
+
+func TestResolveDownloadOnly(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	exe := writeFakeConda(t, opts.DataDir, "conda_standalone", "4.10.3")
+
+	// the downloaded executable can't run here, which mustn't matter
+	pkg := makeCondaPackage(t, "conda-standalone", map[string]string{
+		"standalone_conda/conda.exe": "#!/bin/sh\nexit 1\n",
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".conda") {
+			w.Write(pkg)
+			return
+		}
+		fmt.Fprintf(w, `[{"attrs": {"subdir": %q, "version": "4.11.0", "build_number": 0}, "download_url": "/conda-standalone-4.11.0-0.conda"}]`,
+			PlatformSubdir())
+	}))
+	defer server.Close()
+
+	opts.CondaStandalone = true
+	opts.CondaStandaloneChannel = server.URL
+	opts.DownloadOnly = true
+	opts.SelfTest = true
+
+	got, err := Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got.Executable != exe || got.Version != nil {
+		t.Errorf("Resolve() got = %+v, want %s downloaded without a version", got, exe)
+	}
+	if content, _ := ioutil.ReadFile(exe); !strings.Contains(string(content), "exit 1") {
+		t.Errorf("Resolve() left %q, want the downloaded executable", content)
+	}
+
+	opts.NoInstall = true
+	if _, err := Resolve(context.Background(), opts); err == nil {
+		t.Errorf("Resolve() expected an error combining DownloadOnly and NoInstall")
+	}
+}
+
+func TestResolvePinnedCondaStandalone(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	packages := map[string][]byte{}
+	for _, v := range []string{"24.1.0", "24.3.0"} {
+		packages["/conda-standalone-"+v+"-0.conda"] = makeCondaPackage(t, "conda-standalone", map[string]string{
+			"standalone_conda/conda.exe": "#!/bin/sh\necho conda " + v + "\n",
+		})
+	}
+	var downloads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if pkg, ok := packages[r.URL.Path]; ok {
+			downloads++
+			w.Write(pkg)
+			return
+		}
+		fmt.Fprintf(w, `[
+			{"attrs": {"subdir": %[1]q, "version": "24.1.0"}, "download_url": "/conda-standalone-24.1.0-0.conda"},
+			{"attrs": {"subdir": %[1]q, "version": "24.3.0"}, "download_url": "/conda-standalone-24.3.0-0.conda"}
+		]`, PlatformSubdir())
+	}))
+	defer server.Close()
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.CondaStandalone = true
+	opts.CondaStandaloneChannel = server.URL
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", "")
+
+	tests := []struct {
+		pin           string
+		wantDownloads int
+	}{
+		{"24.1.0", 1},
+		{"24.3.0", 2},
+		// already installed side by side
+		{"24.1.0", 2},
+		{"24.1", 2},
+	}
+	for _, tt := range tests {
+		opts.CondaStandaloneVersion = tt.pin
+		got, err := Resolve(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("Resolve(%s) error = %v", tt.pin, err)
+		}
+		wantVersion, _ := version.NewVersion(tt.pin)
+		want := filepath.Join(opts.DataDir, "conda_standalone-"+wantVersion.String())
+		if got.Executable != want || !got.Version.Equal(wantVersion) {
+			t.Errorf("Resolve(%s) got = %+v, want %s", tt.pin, got, want)
+		}
+		if downloads != tt.wantDownloads {
+			t.Errorf("Resolve(%s) downloaded %d packages in total, want %d", tt.pin, downloads, tt.wantDownloads)
+		}
+	}
+
+	for _, pin := range []string{"24.5.0", "not a version"} {
+		opts.CondaStandaloneVersion = pin
+		if _, err := Resolve(context.Background(), opts); err == nil {
+			t.Errorf("Resolve(%s) expected an error", pin)
+		}
+	}
+}
+
+func TestResolveRejectedConda(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	pkg := makeCondaPackage(t, "conda-standalone", map[string]string{
+		"standalone_conda/conda.exe": "#!/bin/sh\necho conda 24.3.0\n",
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/conda-standalone-24.3.0-0.conda" {
+			w.Write(pkg)
+			return
+		}
+		fmt.Fprintf(w, `[{"attrs": {"subdir": %q, "version": "24.3.0"}, "download_url": "/conda-standalone-24.3.0-0.conda"}]`, PlatformSubdir())
+	}))
+	defer server.Close()
+
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.Conda = true
+	opts.CondaStandalone = true
+	opts.CondaStandaloneChannel = server.URL
+	binDir := filepath.Join(opts.DataDir, "bin")
+	oldConda := writeFakeConda(t, binDir, "conda", "4.6.0")
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", binDir)
+
+	got, err := Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got.Flavor != CondaStandalone || len(got.RejectedConda) != 1 || got.RejectedConda[0].Executable != oldConda ||
+		got.RejectedConda[0].Version.String() != "4.6.0" {
+		t.Errorf("Resolve() got = %+v, want conda-standalone installed and %s reported as rejected", got, oldConda)
+	}
+
+	opts.PreferSystemConda = true
+	got, err = Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	// the conda-standalone installed above is found before falling back to the system conda
+	if got.Flavor != CondaStandalone {
+		t.Errorf("Resolve() got = %+v, want the installed conda-standalone", got)
+	}
+	os.Remove(filepath.Join(opts.DataDir, "conda_standalone"))
+	got, err = Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got.Executable != oldConda || got.Flavor != Conda {
+		t.Errorf("Resolve() got = %+v, want the system conda %s to be preferred", got, oldConda)
+	}
+}
+
+func TestNoInstallFlavor(t *testing.T) {
+	defer serveFixtures(t, "24.3.0")()
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.Micromamba = true
+	opts.CondaStandalone = true
+	opts.NoPathSearch = true
+	opts.DryRun = true
+
+	opts.NoInstallMicromamba = true
+	got, err := Resolve(context.Background(), opts)
+	if err != nil || got.Planned == nil || got.Planned.Flavor != CondaStandalone {
+		t.Errorf("Resolve() with NoInstallMicromamba = %+v, %v, want a planned %s", got.Planned, err, CondaStandalone)
+	}
+
+	opts.NoInstallCondaStandalone = true
+	if _, err := Resolve(context.Background(), opts); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve() with neither flavor installable error = %v, want %v", err, ErrNotFound)
+	}
+
+	if runtime.GOOS != "windows" {
+		exe := writeFakeConda(t, opts.DataDir, "conda_standalone", "24.3.0")
+		if got, err := Resolve(context.Background(), opts); err != nil || got.Executable != exe {
+			t.Errorf("Resolve() = %v, %v, want the existing %v", got.Executable, err, exe)
+		}
+	}
+}
+
+func TestPreferSystem(t *testing.T) {
+	defer serveFixtures(t, "4.6.0")()
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	binDir := filepath.Join(opts.DataDir, "bin")
+	oldMamba := writeFakeConda(t, binDir, "mamba", "0.1.0")
+	if err := ioutil.WriteFile(oldMamba, []byte("#!/bin/sh\necho 0.1.0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", binDir)
+	opts.Mamba = true
+	opts.CondaStandalone = true
+	opts.NoInstall = true
+
+	if _, err := Resolve(context.Background(), opts); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Resolve() error = %v, want ErrNotFound for a mamba older than the minimum", err)
+	}
+
+	opts.PreferSystem = true
+	got, err := Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got.Executable != oldMamba || got.Flavor != Mamba || got.Version.String() != "0.1.0" {
+		t.Errorf("Resolve() got = %+v, want the existing mamba %s", got, oldMamba)
+	}
+
+	// a conda-standalone installed now must still meet the minimum version
+	defer func(r *retry.Retrier) { installRetrier = r }(installRetrier)
+	installRetrier = retry.NewRetrier(1, time.Millisecond, time.Millisecond)
+	os.Remove(oldMamba)
+	opts.NoInstall = false
+	if got, err := Resolve(context.Background(), opts); err == nil {
+		t.Errorf("Resolve() got = %+v, want an error for an installed conda-standalone older than the minimum", got)
+	}
+}
+
+func TestInstallCondaStandaloneFallsBack(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	// A single listing, as listing again would go on to the older builds
+	defer func(r *retry.Retrier) { installRetrier = r }(installRetrier)
+	installRetrier = retry.NewRetrier(1, time.Millisecond, time.Millisecond)
+	scripts := map[string]string{
+		"24.1.0": "#!/bin/sh\ncase $1 in --version) echo conda 24.1.0;; info) echo '{}';; esac\n",
+		"24.3.0": "#!/bin/sh\necho Segmentation fault >&2\nexit 139\n",
+		"24.5.0": "#!/bin/sh\ncase $1 in --version) echo conda 24.5.0;; info) exit 1;; esac\n",
+		"24.7.0": "#!/bin/sh\necho conda 4.0.0\n",
+	}
+	packages := map[string][]byte{}
+	for v, script := range scripts {
+		packages["/conda-standalone-"+v+"-0.conda"] = makeCondaPackage(t, "conda-standalone", map[string]string{
+			"standalone_conda/conda.exe": script,
+		})
+	}
+
+	tests := []struct {
+		name     string
+		versions []string
+		want     string
+	}{
+		{"newest works", []string{"24.1.0"}, "24.1.0"},
+		{"skips broken builds", []string{"24.1.0", "24.3.0", "24.5.0"}, "24.1.0"},
+		{"gives up after the newest builds", []string{"24.1.0", "24.3.0", "24.5.0", "24.7.0"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var downloads []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if pkg, ok := packages[r.URL.Path]; ok {
+					downloads = append(downloads, r.URL.Path)
+					w.Write(pkg)
+					return
+				}
+				var pkgs []string
+				for _, v := range tt.versions {
+					pkgs = append(pkgs, fmt.Sprintf(`{"attrs": {"subdir": %q, "version": %q}, "download_url": "/conda-standalone-%s-0.conda"}`,
+						PlatformSubdir(), v, v))
+				}
+				fmt.Fprintf(w, "[%s]", strings.Join(pkgs, ","))
+			}))
+			defer server.Close()
+
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			opts.CondaStandaloneChannel = server.URL
+			opts.SelfTest = true
+
+			got, err := InstallCondaStandalone(context.Background(), opts)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("InstallCondaStandalone() expected an error when no build works")
+				}
+				if len(downloads) != maxInstallAttempts {
+					t.Errorf("InstallCondaStandalone() downloaded %v, want %d attempts", downloads, maxInstallAttempts)
+				}
+				if _, statErr := os.Stat(newEnsurer(opts).targetExeFilename("conda_standalone")); statErr == nil {
+					t.Errorf("InstallCondaStandalone() left a broken executable behind")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InstallCondaStandalone() error = %v", err)
+			}
+			out, _ := ioutil.ReadFile(got)
+			if !strings.Contains(string(out), "conda "+tt.want) {
+				t.Errorf("InstallCondaStandalone() installed %q, want %s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstallCondaStandaloneRelists(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	defer func(r *retry.Retrier) { installRetrier = r }(installRetrier)
+	installRetrier = retry.NewRetrier(3, time.Millisecond, time.Millisecond)
+	packages := map[string][]byte{
+		"/conda-standalone-24.7.0-0.conda": makeCondaPackage(t, "conda-standalone", map[string]string{
+			"standalone_conda/conda.exe": "#!/bin/sh\necho Segmentation fault >&2\nexit 139\n",
+		}),
+		"/conda-standalone-24.7.0-1.conda": makeCondaPackage(t, "conda-standalone", map[string]string{
+			"standalone_conda/conda.exe": "#!/bin/sh\necho conda 24.7.0\n",
+		}),
+	}
+
+	tests := []struct {
+		name      string
+		fixed     bool
+		wantErr   bool
+		listings  int32
+		downloads int
+	}{
+		{"a fixed build is published", true, false, 2, 2},
+		{"gives up after listing again", false, true, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var listings int32
+			var downloads []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if pkg, ok := packages[r.URL.Path]; ok {
+					downloads = append(downloads, r.URL.Path)
+					w.Write(pkg)
+					return
+				}
+				pkgs := []string{fmt.Sprintf(`{"attrs": {"subdir": %q, "version": "24.7.0", "build_number": 0}, `+
+					`"download_url": "/conda-standalone-24.7.0-0.conda"}`, PlatformSubdir())}
+				// The fixed build is published once the broken one was listed
+				if atomic.AddInt32(&listings, 1) > 1 && tt.fixed {
+					pkgs = append(pkgs, fmt.Sprintf(`{"attrs": {"subdir": %q, "version": "24.7.0", "build_number": 1}, `+
+						`"download_url": "/conda-standalone-24.7.0-1.conda"}`, PlatformSubdir()))
+				}
+				fmt.Fprintf(w, "[%s]", strings.Join(pkgs, ","))
+			}))
+			defer server.Close()
+
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			opts.CondaStandaloneChannel = server.URL
+
+			got, err := InstallCondaStandalone(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InstallCondaStandalone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&listings); got != tt.listings {
+				t.Errorf("InstallCondaStandalone() listed candidates %d times, want %d", got, tt.listings)
+			}
+			if len(downloads) != tt.downloads {
+				t.Errorf("InstallCondaStandalone() downloaded %v, want %d downloads", downloads, tt.downloads)
+			}
+			if tt.wantErr {
+				return
+			}
+			out, _ := ioutil.ReadFile(got)
+			if !strings.Contains(string(out), "conda 24.7.0") {
+				t.Errorf("InstallCondaStandalone() installed %q, want the fixed build", out)
+			}
+		})
+	}
+}
+
+func TestOffline(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	savedMicromamba, savedAnaconda := micromambaApiUrl, anacondaApiUrl
+	defer func() { micromambaApiUrl, anacondaApiUrl = savedMicromamba, savedAnaconda }()
+	micromambaApiUrl, anacondaApiUrl = server.URL, server.URL
+	defer os.Setenv("ENSURECONDA_OFFLINE", os.Getenv("ENSURECONDA_OFFLINE"))
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.Micromamba = true
+	opts.CondaStandalone = true
+	opts.NoPathSearch = true
+
+	for _, value := range []string{"1", "true", "yes"} {
+		os.Setenv("ENSURECONDA_OFFLINE", value)
+		if _, err := Resolve(context.Background(), opts); !errors.Is(err, ErrOffline) {
+			t.Errorf("Resolve() with ENSURECONDA_OFFLINE=%s error = %v, want %v", value, err, ErrOffline)
+		}
+		if _, err := InstallCondaStandalone(context.Background(), opts); !errors.Is(err, ErrOffline) {
+			t.Errorf("InstallCondaStandalone() with ENSURECONDA_OFFLINE=%s error = %v, want %v", value, err, ErrOffline)
+		}
+	}
+	os.Setenv("ENSURECONDA_OFFLINE", "0")
+	opts.Offline = true
+	// Planning conda-standalone needs its channel listing, unlike micromamba
+	opts.Micromamba = false
+	opts.DryRun = true
+	_, err := Resolve(context.Background(), opts)
+	if !errors.Is(err, ErrOffline) {
+		t.Errorf("Resolve() with Offline error = %v, want %v", err, ErrOffline)
+	}
+	var installErr *InstallError
+	if !errors.As(err, &installErr) || installErr.Flavor != CondaStandalone {
+		t.Errorf("Resolve() with Offline error = %#v, want an InstallError for %s", err, CondaStandalone)
+	}
+	if got := atomic.LoadInt32(&requests); got != 0 {
+		t.Errorf("offline mode made %d requests", got)
+	}
+
+	if runtime.GOOS != "windows" {
+		exe := writeFakeConda(t, opts.DataDir, "conda_standalone", "24.3.0")
+		opts.DryRun = false
+		if got, err := Resolve(context.Background(), opts); err != nil || got.Executable != exe {
+			t.Errorf("Resolve() = %v, %v, want the existing %v", got.Executable, err, exe)
+		}
+	}
+
+	opts.Offline = false
+	if newEnsurer(opts).offline() {
+		t.Errorf("offline() with ENSURECONDA_OFFLINE=0 = true, want false")
+	}
+}
+
+func TestListVersions(t *testing.T) {
+	defer serveFixtures(t, "24.3.0")()
+	_, restore := isolateCondarc(t)
+	defer restore()
+	defer os.Setenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL", os.Getenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL"))
+	os.Unsetenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+
+	tests := []struct {
+		name    string
+		flavor  Flavor
+		want    string
+		wantErr bool
+	}{
+		{"conda-standalone", CondaStandalone, "24.3.0", false},
+		{"latest micromamba only", Micromamba, "", true},
+		{"not installable", Conda, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ListVersions(context.Background(), opts, tt.flavor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ListVersions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (len(got) != 1 || got[0].Attrs.Version != tt.want) {
+				t.Errorf("ListVersions() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCondaStandalonePackage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/package/anaconda/internal-conda-standalone/files" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `[{"attrs": {"subdir": %q, "version": "24.3.0"}, "download_url": "/download/conda-standalone.conda"}]`, PlatformSubdir())
+	}))
+	defer server.Close()
+	defer func(url string) { anacondaApiUrl = url }(anacondaApiUrl)
+	anacondaApiUrl = server.URL + "/package"
+	_, restore := isolateCondarc(t)
+	defer restore()
+	defer os.Setenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL", os.Getenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL"))
+	os.Unsetenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+
+	tests := []struct {
+		pkg     string
+		wantErr bool
+	}{
+		{"internal-conda-standalone", false},
+		{"", true},
+		{"../internal-conda-standalone", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pkg, func(t *testing.T) {
+			opts.CondaStandalonePackage = tt.pkg
+			got, err := ListVersions(context.Background(), opts, CondaStandalone)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ListVersions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (len(got) != 1 || got[0].Attrs.Version != "24.3.0") {
+				t.Errorf("ListVersions() got = %v, want 24.3.0", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,244 @@
+package ensureconda
+
+import (
+	"context"
+	"fmt"
+	"github.com/flowchartsman/retry"
+	"github.com/gofrs/flock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileLock guards writes to a file in the data directory against concurrent ensureconda
+// processes.
+type fileLock interface {
+	TryLock() (bool, error)
+	Unlock() error
+	Locked() bool
+	Path() string
+}
+
+// DefaultLockTimeout is how long an install waits for another process to finish installing
+// the same executable.
+const DefaultLockTimeout = 5 * time.Minute
+
+// DefaultFileLockRetries and DefaultFileLockMaxDelay bound waiting for the file lock of an
+// executable being written, long enough for many installers sharing a data directory.
+const (
+	DefaultFileLockRetries  = 30
+	DefaultFileLockMaxDelay = 10 * time.Second
+)
+
+// dirLockStaleAfter is the age after which a lock directory is assumed to have been left
+// behind by a process that died while holding it.
+const dirLockStaleAfter = 10 * time.Minute
+
+// dirLockRefreshInterval is how often a held lock directory is touched, so that it never
+// looks stale however long its holder takes, e.g. downloading conda-standalone slowly.
+var dirLockRefreshInterval = dirLockStaleAfter / 5
+
+// dirLock is a lock based on the atomicity of mkdir, for file systems where flock is
+// unreliable such as NFS or the DrvFS/9p mounts WSL uses for Windows drives.
+type dirLock struct {
+	path   string
+	locked bool
+	done   chan struct{}
+}
+
+func (l *dirLock) TryLock() (bool, error) {
+	if l.locked {
+		return true, nil
+	}
+	err := os.Mkdir(l.path, 0700)
+	if os.IsExist(err) {
+		if !l.breakStale() {
+			return false, nil
+		}
+		err = os.Mkdir(l.path, 0700)
+		if os.IsExist(err) {
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	l.locked = true
+	l.done = make(chan struct{})
+	go l.refresh(l.done, dirLockRefreshInterval)
+	return true, nil
+}
+
+// breakStale removes the lock directory if it was left behind by a dead process, reporting
+// whether it did.  Waiters break a stale lock one at a time, under a second lock directory,
+// and check it is still stale once they hold that: otherwise a slow waiter could remove the
+// lock directory another waiter has just broken and created again.
+func (l *dirLock) breakStale() bool {
+	if !isStale(l.path) {
+		return false
+	}
+	breaker := l.path + ".break"
+	if err := os.Mkdir(breaker, 0700); err != nil {
+		// A waiter that died while breaking the lock mustn't keep it from ever being broken
+		if os.IsExist(err) && isStale(breaker) {
+			_ = os.Remove(breaker)
+		}
+		return false
+	}
+	defer os.Remove(breaker)
+	return isStale(l.path) && os.RemoveAll(l.path) == nil
+}
+
+// isStale reports whether path was last modified over dirLockStaleAfter ago.
+func isStale(path string) bool {
+	st, err := os.Stat(path)
+	return err == nil && time.Since(st.ModTime()) >= dirLockStaleAfter
+}
+
+// refresh touches the lock directory every interval until done is closed.
+func (l *dirLock) refresh(done chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			_ = os.Chtimes(l.path, now, now)
+		}
+	}
+}
+
+func (l *dirLock) Unlock() error {
+	if !l.locked {
+		return nil
+	}
+	l.locked = false
+	close(l.done)
+	return os.RemoveAll(l.path)
+}
+
+func (l *dirLock) Locked() bool {
+	return l.locked
+}
+
+func (l *dirLock) Path() string {
+	return l.path
+}
+
+// lockPath returns the path of the lock file guarding name.  Lock files are kept in their
+// own directory, Options.LockDir or a subdirectory of the data directory, so they aren't
+// mistaken for installed executables.
+func (e *ensurer) lockPath(name string) (string, error) {
+	lockDir := e.opts.LockDir
+	if lockDir == "" {
+		lockDir = filepath.Join(e.dataDir, "locks")
+	}
+	if err := mkdirAll(lockDir, e.dataDirMode()); err != nil {
+		return "", fmt.Errorf("can't create the install lock directory (choose another with --lock-dir): %w", err)
+	}
+	return filepath.Join(lockDir, name+".lock"), nil
+}
+
+// newLock returns the lock guarding name, falling back to a lock directory when the lock
+// lives on a file system that doesn't support flock reliably.
+func (e *ensurer) newLock(name string) (fileLock, error) {
+	path, err := e.lockPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if !supportsFlock(filepath.Dir(path)) {
+		e.log.WithField("lockPath", path).Debug("file system doesn't support flock, using a lock directory")
+		return &dirLock{path: path}, nil
+	}
+	return flock.New(path), nil
+}
+
+// fileLockRetrier returns the retrier waiting for the file lock of an executable being
+// written; see Options.FileLockRetries.
+func (e *ensurer) fileLockRetrier() (*retry.Retrier, error) {
+	retries := e.opts.FileLockRetries
+	if value := os.Getenv("ENSURECONDA_FILE_LOCK_RETRIES"); retries == 0 && value != "" {
+		var err error
+		if retries, err = strconv.Atoi(value); err != nil || retries <= 0 {
+			return nil, fmt.Errorf("invalid ENSURECONDA_FILE_LOCK_RETRIES %q, expected a positive number", value)
+		}
+	}
+	if retries == 0 {
+		retries = DefaultFileLockRetries
+	}
+	maxDelay := e.opts.FileLockMaxDelay
+	if value := os.Getenv("ENSURECONDA_FILE_LOCK_MAX_DELAY"); maxDelay == 0 && value != "" {
+		var err error
+		if maxDelay, err = time.ParseDuration(value); err != nil || maxDelay <= 0 {
+			return nil, fmt.Errorf("invalid ENSURECONDA_FILE_LOCK_MAX_DELAY %q, expected a duration such as 10s", value)
+		}
+	}
+	if maxDelay == 0 {
+		maxDelay = DefaultFileLockMaxDelay
+	}
+	return retry.NewRetrier(retries, 100*time.Millisecond, maxDelay), nil
+}
+
+// ownerFile records the pid of the process holding a lock, for reporting on contention.
+func ownerFile(lock fileLock) string {
+	if _, ok := lock.(*dirLock); ok {
+		return filepath.Join(lock.Path(), "pid")
+	}
+	return lock.Path() + ".pid"
+}
+
+// acquireLock waits for lock until it is acquired, ctx is done or the lock timeout expires.
+func (e *ensurer) acquireLock(ctx context.Context, lock fileLock) error {
+	timeout := e.opts.LockTimeout
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	e.log.WithField("lockPath", lock.Path()).Debug("acquiring install lock")
+	waiting := false
+	for {
+		locked, err := lock.TryLock()
+		if err != nil {
+			return err
+		}
+		if locked {
+			_ = ioutil.WriteFile(ownerFile(lock), []byte(strconv.Itoa(os.Getpid())), 0600)
+			return nil
+		}
+		if !waiting {
+			e.log.WithField("lockPath", lock.Path()).Infof("waiting for another process installing%s", describeOwner(lock))
+			waiting = true
+		}
+		select {
+		case <-ctx.Done():
+			if err := parent.Err(); err != nil {
+				return fmt.Errorf("waiting for install lock %s: %w", lock.Path(), err)
+			}
+			return fmt.Errorf("timed out after %s waiting for install lock %s%s", timeout, lock.Path(), describeOwner(lock))
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// releaseLock unlocks a lock taken by acquireLock.
+func releaseLock(lock fileLock) error {
+	_ = os.Remove(ownerFile(lock))
+	return lock.Unlock()
+}
+
+func describeOwner(lock fileLock) string {
+	st, err := os.Stat(ownerFile(lock))
+	if err != nil {
+		return ""
+	}
+	pid, _ := ioutil.ReadFile(ownerFile(lock))
+	return fmt.Sprintf(" (held by pid %s for %s)",
+		strings.TrimSpace(string(pid)), time.Since(st.ModTime()).Round(time.Second))
+}
@@ -0,0 +1,26 @@
+package ensureconda
+
+import "syscall"
+
+// Magic numbers reported by statfs for file systems where flock is a no-op or unreliable.
+const (
+	nfsSuperMagic  = 0x6969
+	smbSuperMagic  = 0x517b
+	cifsMagic      = 0xff534d42
+	smb2MagicNum   = 0xfe534d42
+	v9fsMagic      = 0x01021997 // WSL2 DrvFS mounts
+	drvfsMagic     = 0x53464846 // WSL1 DrvFS mounts
+	fuseSuperMagic = 0x65735546
+)
+
+func supportsFlock(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return true
+	}
+	switch uint32(st.Type) {
+	case nfsSuperMagic, smbSuperMagic, cifsMagic, smb2MagicNum, v9fsMagic, drvfsMagic, fuseSuperMagic:
+		return false
+	}
+	return true
+}
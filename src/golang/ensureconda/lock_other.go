@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package ensureconda
+
+func supportsFlock(dir string) bool {
+	return true
+}
@@ -0,0 +1,182 @@
+package ensureconda
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestLock returns the lock guarding name, failing the test when it can't be created.
+func newTestLock(t *testing.T, e *ensurer, name string) fileLock {
+	lock, err := e.newLock(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lock
+}
+
+func TestDirLock(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+	path, err := e.lockPath("micromamba")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := &dirLock{path: path}
+	second := &dirLock{path: path}
+	if locked, err := first.TryLock(); !locked || err != nil {
+		t.Fatalf("TryLock() = %v, %v, want true", locked, err)
+	}
+	defer first.Unlock()
+	if locked, err := second.TryLock(); locked || err != nil {
+		t.Errorf("TryLock() on a held lock = %v, %v, want false", locked, err)
+	}
+
+	// a lock left behind by a dead process is taken over once it is stale
+	stale := time.Now().Add(-2 * dirLockStaleAfter)
+	if err := os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	if locked, err := second.TryLock(); !locked || err != nil {
+		t.Errorf("TryLock() on a stale lock = %v, %v, want true", locked, err)
+	}
+	if err := second.Unlock(); err != nil {
+		t.Errorf("Unlock() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Unlock() left %s behind", path)
+	}
+}
+
+func TestDirLockStaleRace(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	path, err := newEnsurer(opts).lockPath("micromamba")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// waiters breaking the same stale lock at once must not all end up holding it
+	for i := 0; i < 20; i++ {
+		if err := os.Mkdir(path, 0700); err != nil {
+			t.Fatal(err)
+		}
+		stale := time.Now().Add(-2 * dirLockStaleAfter)
+		if err := os.Chtimes(path, stale, stale); err != nil {
+			t.Fatal(err)
+		}
+
+		locks := make([]*dirLock, 8)
+		results := make(chan bool, len(locks))
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for j := range locks {
+			locks[j] = &dirLock{path: path}
+			wg.Add(1)
+			go func(lock *dirLock) {
+				defer wg.Done()
+				<-start
+				locked, err := lock.TryLock()
+				if err != nil {
+					t.Errorf("TryLock() error = %v", err)
+				}
+				results <- locked
+			}(locks[j])
+		}
+		close(start)
+		wg.Wait()
+		close(results)
+		holders := 0
+		for locked := range results {
+			if locked {
+				holders++
+			}
+		}
+		if holders != 1 {
+			t.Fatalf("%d waiters took over the stale lock, want 1", holders)
+		}
+		for _, lock := range locks {
+			_ = lock.Unlock()
+		}
+	}
+}
+
+func TestAcquireLockTimeout(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.LockTimeout = 200 * time.Millisecond
+	e := newEnsurer(opts)
+
+	holder := newTestLock(t, e, "conda_exe_install")
+	if err := e.acquireLock(context.Background(), holder); err != nil {
+		t.Fatalf("acquireLock() error = %v", err)
+	}
+
+	waiter := newTestLock(t, e, "conda_exe_install")
+	err := e.acquireLock(context.Background(), waiter)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Errorf("acquireLock() error = %v, want a timeout naming the holding pid", err)
+	}
+
+	if err := releaseLock(holder); err != nil {
+		t.Fatalf("releaseLock() error = %v", err)
+	}
+	if err := e.acquireLock(context.Background(), waiter); err != nil {
+		t.Errorf("acquireLock() after release error = %v", err)
+	}
+	_ = releaseLock(waiter)
+}
+
+func TestDirLockRefreshedWhileHeld(t *testing.T) {
+	defer func(interval time.Duration) { dirLockRefreshInterval = interval }(dirLockRefreshInterval)
+	dirLockRefreshInterval = 10 * time.Millisecond
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	path, err := newEnsurer(opts).lockPath("conda_exe_install")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held := &dirLock{path: path}
+	if locked, err := held.TryLock(); !locked || err != nil {
+		t.Fatalf("TryLock() = %v, %v, want true", locked, err)
+	}
+	defer held.Unlock()
+	// a holder outliving dirLockStaleAfter keeps its lock
+	stale := time.Now().Add(-2 * dirLockStaleAfter)
+	if err := os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if st, err := os.Stat(path); err == nil && time.Since(st.ModTime()) < dirLockStaleAfter {
+			break
+		}
+		time.Sleep(dirLockRefreshInterval)
+	}
+	if locked, err := (&dirLock{path: path}).TryLock(); locked || err != nil {
+		t.Errorf("TryLock() on a held lock past dirLockStaleAfter = %v, %v, want false", locked, err)
+	}
+}
+
+func TestLockDirNotWritable(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	blocker := filepath.Join(opts.DataDir, "not-a-dir")
+	if err := ioutil.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts.LockDir = filepath.Join(blocker, "locks")
+
+	_, _, err := newEnsurer(opts).installCondaStandalone(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "install lock directory") {
+		t.Errorf("installCondaStandalone() error = %v, want the lock directory reported", err)
+	}
+}
@@ -0,0 +1,126 @@
+package ensureconda
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hashicorp/go-version"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// InstallMetadata records where an executable installed by ensureconda came from.  It is
+// kept in a sidecar next to the executable, e.g. conda_standalone.json.
+type InstallMetadata struct {
+	// Url is the package archive or executable the executable was installed from.
+	Url string `json:"url"`
+	// Version is the version of the package, or the one the executable reports when the
+	// package didn't say.  It is empty when neither is known.
+	Version     string `json:"version,omitempty"`
+	BuildNumber int32  `json:"build_number,omitempty"`
+	// Sha256 is the checksum of the installed executable.
+	Sha256      string    `json:"sha256"`
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// metadataFileName returns the name of the sidecar of executable, without its .exe suffix.
+func metadataFileName(executable string) string {
+	return strings.TrimSuffix(executable, ".exe") + ".json"
+}
+
+// ReadMetadata returns the metadata recorded when executable was installed.  The error
+// satisfies os.IsNotExist for executables ensureconda didn't install.
+func ReadMetadata(executable string) (*InstallMetadata, error) {
+	data, err := ioutil.ReadFile(metadataFileName(executable))
+	if err != nil {
+		return nil, err
+	}
+	var metadata InstallMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// writeMetadata records metadata for the freshly installed executable, filling in its
+// checksum and the install time.  Provenance is informational, so failures are only logged.
+func (e *ensurer) writeMetadata(executable string, metadata InstallMetadata) {
+	sum, err := fileSha256(executable)
+	if err == nil {
+		metadata.Sha256 = sum
+		metadata.InstalledAt = time.Now().UTC()
+		err = e.saveMetadata(executable, metadata)
+	}
+	if err != nil {
+		e.log.WithField("executable", executable).WithError(err).Warn("could not record install metadata")
+	}
+}
+
+// recordVersion fills in the version of executable in its sidecar when the package didn't
+// tell it.
+func (e *ensurer) recordVersion(executable string, exeVersion string) {
+	metadata, err := ReadMetadata(executable)
+	if err != nil || metadata.Version != "" {
+		return
+	}
+	metadata.Version = exeVersion
+	if err := e.saveMetadata(executable, *metadata); err != nil {
+		e.log.WithField("executable", executable).WithError(err).Warn("could not record install metadata")
+	}
+}
+
+// pinnedVersionFromMetadata returns the version of executable, the pinned conda-standalone,
+// without running it, as starting conda-standalone is slow.  Its sidecar must record exactly
+// the pinned version and a checksum the executable still matches; nil is returned otherwise.
+func (e *ensurer) pinnedVersionFromMetadata(executable string, pinned *version.Version) *version.Version {
+	if assertExecutable(executable) != nil {
+		return nil
+	}
+	metadata, err := ReadMetadata(executable)
+	if err != nil {
+		return nil
+	}
+	recorded, err := version.NewVersion(metadata.Version)
+	if err != nil || !recorded.Equal(pinned) {
+		return nil
+	}
+	sum, err := fileSha256(executable)
+	if err != nil || sum != metadata.Sha256 {
+		e.log.WithField("executable", executable).Debug("executable doesn't match the checksum in its install metadata")
+		return nil
+	}
+	return recorded
+}
+
+func (e *ensurer) saveMetadata(executable string, metadata InstallMetadata) error {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	fileName := metadataFileName(executable)
+	tmpFileName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpFileName, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := replaceFile(tmpFileName, fileName); err != nil {
+		_ = os.Remove(tmpFileName)
+		return err
+	}
+	return nil
+}
+
+func fileSha256(fileName string) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
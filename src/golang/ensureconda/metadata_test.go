@@ -0,0 +1,143 @@
+package ensureconda
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+	exe := filepath.Join(opts.DataDir, "conda_standalone.exe")
+	if err := ioutil.WriteFile(exe, []byte("conda"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	e.writeMetadata(exe, InstallMetadata{Url: "https://example.com/conda.exe", BuildNumber: 2})
+	e.recordVersion(exe, "24.3.0")
+	// A version from the package wins over the one probed later
+	e.recordVersion(exe, "1.0.0")
+	got, err := ReadMetadata(exe)
+	if err != nil {
+		t.Fatalf("ReadMetadata() error = %v", err)
+	}
+	if filepath.Base(metadataFileName(exe)) != "conda_standalone.json" {
+		t.Errorf("metadataFileName() got = %v, want conda_standalone.json", metadataFileName(exe))
+	}
+	sum := sha256.Sum256([]byte("conda"))
+	if got.Url != "https://example.com/conda.exe" || got.Version != "24.3.0" || got.BuildNumber != 2 ||
+		got.Sha256 != hex.EncodeToString(sum[:]) || got.InstalledAt.Before(start) {
+		t.Errorf("ReadMetadata() got = %+v", got)
+	}
+
+	if _, err := ReadMetadata(filepath.Join(opts.DataDir, "micromamba")); !os.IsNotExist(err) {
+		t.Errorf("ReadMetadata() error = %v, want a missing file", err)
+	}
+}
+
+func TestInstallWritesMetadata(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	defer serveFixtures(t, "24.3.0")()
+	_, restore := isolateCondarc(t)
+	defer restore()
+	defer os.Setenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL", os.Getenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL"))
+	os.Unsetenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.Micromamba = true
+	opts.CondaStandalone = true
+	opts.NoPathSearch = true
+
+	tests := []struct {
+		name        string
+		install     func() (string, error)
+		wantVersion string
+		wantUrl     string
+	}{
+		{"micromamba", func() (string, error) {
+			result, err := Resolve(context.Background(), opts)
+			return result.Executable, err
+		}, "1.5.8", "/latest"},
+		{"conda-standalone", func() (string, error) {
+			return InstallCondaStandalone(context.Background(), opts)
+		}, "24.3.0", "/download/conda-standalone.conda"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exe, err := tt.install()
+			if err != nil {
+				t.Fatalf("install error = %v", err)
+			}
+			got, err := ReadMetadata(exe)
+			if err != nil {
+				t.Fatalf("ReadMetadata() error = %v", err)
+			}
+			sum, _ := fileSha256(exe)
+			if got.Version != tt.wantVersion || !strings.HasSuffix(got.Url, tt.wantUrl) || got.Sha256 != sum {
+				t.Errorf("ReadMetadata() got = %+v, want version %v from %v", got, tt.wantVersion, tt.wantUrl)
+			}
+		})
+	}
+}
+
+func TestPinnedVersionFromMetadata(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	tests := []struct {
+		name            string
+		recordedVersion string
+		tamper          bool
+		wantRun         bool
+	}{
+		{"recorded", "24.3.0", false, false},
+		{"other version", "24.1.0", false, true},
+		{"checksum mismatch", "24.3.0", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := initTetEnv()
+			defer os.RemoveAll(opts.DataDir)
+			opts.CondaStandalone = true
+			opts.CondaStandaloneVersion = "24.3.0"
+			opts.NoInstall = true
+			opts.NoPathSearch = true
+			e := newEnsurer(opts)
+			runs := filepath.Join(opts.DataDir, "runs")
+			exe := e.targetExeFilename(e.condaStandaloneExeName())
+			script := "#!/bin/sh\necho run >> " + runs + "\necho conda 24.3.0\n"
+			if err := ioutil.WriteFile(exe, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			e.writeMetadata(exe, InstallMetadata{Url: "https://example.com/conda-standalone.conda", Version: tt.recordedVersion})
+			if tt.tamper {
+				if err := ioutil.WriteFile(exe, []byte(script+"# changed\n"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := Resolve(context.Background(), opts)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if got.Executable != exe || got.Version.String() != "24.3.0" {
+				t.Errorf("Resolve() got = %+v, want %s 24.3.0", got, exe)
+			}
+			if _, err := os.Stat(runs); (err == nil) != tt.wantRun {
+				t.Errorf("Resolve() ran the executable = %v, want %v", err == nil, tt.wantRun)
+			}
+		})
+	}
+}
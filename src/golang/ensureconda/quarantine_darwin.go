@@ -0,0 +1,17 @@
+package ensureconda
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// clearQuarantine removes the com.apple.quarantine attribute some setups put on downloaded
+// files, which keeps Gatekeeper from refusing to run the executable.  It is usually absent,
+// so failures are only logged.
+func (e *ensurer) clearQuarantine(path string) {
+	out, err := exec.Command("xattr", "-d", "com.apple.quarantine", path).CombinedOutput()
+	if err != nil {
+		e.log.WithField("executable", path).WithError(err).
+			Debugf("could not remove quarantine attribute: %s", strings.TrimSpace(string(out)))
+	}
+}
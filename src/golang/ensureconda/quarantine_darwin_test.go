@@ -0,0 +1,30 @@
+package ensureconda
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestClearQuarantine(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+
+	exe := filepath.Join(opts.DataDir, "micromamba")
+	if err := ioutil.WriteFile(exe, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("xattr", "-w", "com.apple.quarantine", "0081;00000000;ensureconda;", exe).CombinedOutput(); err != nil {
+		t.Skipf("cannot set quarantine attribute: %v: %s", err, out)
+	}
+
+	e := newEnsurer(opts)
+	e.clearQuarantine(exe)
+	if err := exec.Command("xattr", "-p", "com.apple.quarantine", exe).Run(); err == nil {
+		t.Errorf("clearQuarantine() left the quarantine attribute on %s", exe)
+	}
+	// clearing an executable without the attribute is not an error
+	e.clearQuarantine(exe)
+}
@@ -0,0 +1,6 @@
+//go:build !darwin
+// +build !darwin
+
+package ensureconda
+
+func (e *ensurer) clearQuarantine(path string) {}
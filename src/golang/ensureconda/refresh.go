@@ -0,0 +1,70 @@
+package ensureconda
+
+import (
+	"context"
+	"github.com/hashicorp/go-version"
+	"os"
+)
+
+// Refreshed reports a managed executable replaced by Refresh.
+type Refreshed struct {
+	Flavor     Flavor
+	Executable string
+	// OldVersion and NewVersion are nil when they couldn't be determined, e.g. for another
+	// Platform.
+	OldVersion *version.Version
+	NewVersion *version.Version
+}
+
+// Refresh reinstalls the newest micromamba and conda-standalone over those already in the
+// data directory, as ForceInstall would, e.g. to update a shared build image.  Executables
+// that were never installed are left alone.
+func Refresh(ctx context.Context, opts Options) ([]Refreshed, error) {
+	e := newEnsurer(opts)
+	if err := e.checkPlatform(); err != nil {
+		return nil, err
+	}
+	if e.versionSpecErr != nil {
+		return nil, e.versionSpecErr
+	}
+
+	micromambaCheck := e.flavorCheck(Micromamba)
+	condaCheck := e.flavorCheck(CondaStandalone)
+	managed := []struct {
+		flavor  Flavor
+		exeName string
+		check   versionCheck
+		install func(ctx context.Context) (string, *version.Version, error)
+	}{
+		{Micromamba, "micromamba", micromambaCheck, func(ctx context.Context) (string, *version.Version, error) {
+			exe, err := e.installMicromamba(ctx)
+			if err != nil {
+				return "", nil, err
+			}
+			exeVersion, err := e.verifyInstall(ctx, exe, micromambaCheck)
+			return exe, exeVersion, err
+		}},
+		{CondaStandalone, e.condaStandaloneExeName(), condaCheck, e.installCondaStandalone},
+	}
+
+	var refreshed []Refreshed
+	for _, m := range managed {
+		exe := e.targetExeFilename(m.exeName)
+		if _, err := os.Stat(exe); os.IsNotExist(err) {
+			e.log.WithField("executable", exe).Debugf("%s is not installed, nothing to refresh", m.flavor)
+			continue
+		} else if err != nil {
+			return refreshed, err
+		}
+		var oldVersion *version.Version
+		if !e.downloadOnly() {
+			oldVersion, _, _ = m.check(ctx, exe)
+		}
+		exe, newVersion, err := m.install(ctx)
+		if err != nil {
+			return refreshed, err
+		}
+		refreshed = append(refreshed, Refreshed{Flavor: m.flavor, Executable: exe, OldVersion: oldVersion, NewVersion: newVersion})
+	}
+	return refreshed, nil
+}
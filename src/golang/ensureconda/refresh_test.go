@@ -0,0 +1,52 @@
+package ensureconda
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestRefresh(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	defer serveFixtures(t, "24.3.0")()
+	_, restore := isolateCondarc(t)
+	defer restore()
+	defer os.Setenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL", os.Getenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL"))
+	os.Unsetenv("ENSURECONDA_CONDA_STANDALONE_CHANNEL")
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+
+	got, err := Refresh(context.Background(), opts)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("Refresh() = %v, %v, want nothing refreshed in an empty data directory", got, err)
+	}
+
+	writeFakeConda(t, opts.DataDir, "conda_standalone", "23.1.0")
+	micromamba := filepath.Join(opts.DataDir, "micromamba")
+	if err := ioutil.WriteFile(micromamba, []byte("#!/bin/sh\necho 1.0.0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	got, err = Refresh(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	want := fmt.Sprintf("[{%s %s 1.0.0 1.5.8} {%s %s 23.1.0 24.3.0}]",
+		Micromamba, micromamba, CondaStandalone, filepath.Join(opts.DataDir, "conda_standalone"))
+	if gotString := refreshedString(got); gotString != want {
+		t.Errorf("Refresh() got = %v, want %v", gotString, want)
+	}
+}
+
+func refreshedString(refreshed []Refreshed) string {
+	var s []string
+	for _, r := range refreshed {
+		s = append(s, fmt.Sprintf("{%s %s %s %s}", r.Flavor, r.Executable, r.OldVersion, r.NewVersion))
+	}
+	return fmt.Sprint(s)
+}
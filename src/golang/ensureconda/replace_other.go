@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package ensureconda
+
+import "os"
+
+// replaceFile atomically moves src over dst.  Processes already running dst keep the old
+// file open.
+func replaceFile(src, dst string) error {
+	return os.Rename(src, dst)
+}
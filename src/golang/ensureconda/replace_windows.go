@@ -0,0 +1,28 @@
+package ensureconda
+
+import "os"
+
+// replaceFile moves src over dst.  Windows refuses to replace an executable that is
+// running, but does allow renaming it, so a dst in use is moved aside to dst.old first
+// and put back if src can't take its place.
+func replaceFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := os.Stat(dst); statErr != nil {
+		return err
+	}
+	old := dst + ".old"
+	// A previous replacement may have left an old copy behind, still in use or not
+	_ = os.Remove(old)
+	if err := os.Rename(dst, old); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		_ = os.Rename(old, dst)
+		return err
+	}
+	_ = os.Remove(old)
+	return nil
+}
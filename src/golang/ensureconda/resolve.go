@@ -0,0 +1,471 @@
+package ensureconda
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-version"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// versionCheck reports the version of an executable and whether it is acceptable, giving up
+// on an executable still running once ctx is done.
+type versionCheck func(ctx context.Context, executable string) (*version.Version, bool, error)
+
+// versionRequirement is what the version of an executable must satisfy to be used.
+type versionRequirement struct {
+	description string
+	accepts     func(*version.Version) bool
+}
+
+func minVersionRequirement(minVersion *version.Version) versionRequirement {
+	return versionRequirement{">=" + minVersion.String(), func(v *version.Version) bool {
+		return v.GreaterThanOrEqual(minVersion)
+	}}
+}
+
+// anyVersionRequirement accepts every version, as long as one is reported.
+var anyVersionRequirement = versionRequirement{"any", func(*version.Version) bool { return true }}
+
+// specVersionRequirement parses comma separated pep440-style version specifiers such as
+// ">=23.1,<24".  A bare version is a minimum, as accepted by MinCondaVersion, and like pip,
+// pre-releases only satisfy specifiers mentioning one.
+func specVersionRequirement(spec string) (versionRequirement, error) {
+	var clauses []string
+	for _, clause := range strings.Split(spec, ",") {
+		clause = strings.TrimSpace(clause)
+		switch {
+		case strings.HasPrefix(clause, "==="):
+			return versionRequirement{}, fmt.Errorf("invalid version specifier %q: arbitrary equality is not supported", clause)
+		case strings.HasPrefix(clause, "==") && strings.HasSuffix(clause, ".*"):
+			// ==1.5.* is ~>1.5.0 for hashicorp/go-version, i.e. >=1.5.0,<1.6
+			clause = "~>" + strings.TrimSuffix(clause[2:], ".*") + ".0"
+		case strings.HasPrefix(clause, "=="):
+			clause = "=" + clause[2:]
+		case strings.HasPrefix(clause, "~="):
+			clause = "~>" + clause[2:]
+		case clause != "" && strings.IndexAny(clause[:1], "<>=!") < 0:
+			clause = ">=" + clause
+		}
+		if strings.Contains(clause, "*") {
+			return versionRequirement{}, fmt.Errorf("invalid version specifier %q: wildcards are only supported with ==", clause)
+		}
+		clauses = append(clauses, clause)
+	}
+	constraints, err := version.NewConstraint(strings.Join(clauses, ","))
+	if err != nil {
+		return versionRequirement{}, fmt.Errorf("invalid version specifier %q: %w", spec, err)
+	}
+	return versionRequirement{spec, constraints.Check}, nil
+}
+
+// executableSatisfies returns a check that an executable's --version output reports a
+// version meeting requirement on a line in one of the given styles; see
+// parseVersionOutput.
+func (e *ensurer) executableSatisfies(requirement versionRequirement, prefixes ...string) versionCheck {
+	return func(ctx context.Context, executable string) (*version.Version, bool, error) {
+		stdout, err := e.versionOutput(ctx, executable)
+		e.log.WithFields(log.Fields{
+			"executable":    executable,
+			"versionOutput": string(stdout),
+			"wantVersion":   requirement.description,
+		}).Debug("Detecting executable version")
+		if err != nil {
+			return nil, false, err
+		}
+		exeVersion := parseVersionOutput(string(stdout), prefixes...)
+		return exeVersion, exeVersion != nil && requirement.accepts(exeVersion), nil
+	}
+}
+
+// executableHasMinVersion returns a check that an executable reports at least minVersion.
+func (e *ensurer) executableHasMinVersion(minVersion *version.Version, prefixes ...string) versionCheck {
+	return e.executableSatisfies(minVersionRequirement(minVersion), prefixes...)
+}
+
+// versionPrefixes are the styles of --version output of each flavor; see
+// parseVersionOutput.  mamba 1.x prints "mamba 1.5.8" followed by the conda version, while
+// mamba 2.x and micromamba print a bare version.
+var versionPrefixes = map[Flavor][]string{
+	Mamba:           {"mamba", ""},
+	Micromamba:      {"micromamba", ""},
+	Conda:           {"conda"},
+	CondaStandalone: {"conda"},
+}
+
+// flavorCheck returns a check that an executable of flavor meets the version requirement
+// for that flavor.
+func (e *ensurer) flavorCheck(flavor Flavor) versionCheck {
+	requirement := e.condaRequirement
+	if flavor == Mamba || flavor == Micromamba {
+		requirement = e.mambaRequirement
+	}
+	return e.executableSatisfies(requirement, versionPrefixes[flavor]...)
+}
+
+// CheckExecutable runs path --version as Resolve does for executables of flavor, returning
+// the version it reports and whether that is at least DefaultMinMambaVersion or
+// DefaultMinCondaVersion.  version is empty when none could be parsed from the output, and
+// err is set when the executable couldn't be run.
+func CheckExecutable(path string, flavor Flavor) (version string, ok bool, err error) {
+	if _, known := versionPrefixes[flavor]; !known {
+		return "", false, fmt.Errorf("unknown flavor %q", flavor)
+	}
+	exeVersion, ok, err := newEnsurer(Options{}).flavorCheck(flavor)(context.Background(), path)
+	if exeVersion != nil {
+		version = exeVersion.String()
+	}
+	return version, ok, err
+}
+
+// probeKey identifies an executable file, so that one reached through several PATH entries
+// or symlinks is only run once, while one replaced by an install is run again.
+type probeKey struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// versionProbe holds the --version output of an executable once it has run.
+type versionProbe struct {
+	once   sync.Once
+	stdout []byte
+	err    error
+}
+
+// versionOutput runs executable --version, reusing the output of earlier runs of the same
+// file during this Resolve, as starting conda-standalone in particular is slow.
+func (e *ensurer) versionOutput(ctx context.Context, executable string) ([]byte, error) {
+	key, err := newProbeKey(executable)
+	if err != nil {
+		return runVersion(ctx, executable)
+	}
+	e.probesMu.Lock()
+	probe := e.probes[key]
+	if probe == nil {
+		probe = &versionProbe{}
+		e.probes[key] = probe
+	}
+	e.probesMu.Unlock()
+
+	probe.once.Do(func() {
+		probe.stdout, probe.err = runVersion(ctx, executable)
+	})
+	return probe.stdout, probe.err
+}
+
+// runVersion returns the --version output of executable, taken from stderr when nothing is
+// printed to stdout as some wrapper shims do.
+func runVersion(ctx context.Context, executable string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, executable, "--version")
+	cmd.Stderr = &stderr
+	stdout, err := cmd.Output()
+	if len(bytes.TrimSpace(stdout)) == 0 {
+		stdout = stderr.Bytes()
+	}
+	return stdout, err
+}
+
+func newProbeKey(executable string) (probeKey, error) {
+	path, err := filepath.Abs(executable)
+	if err != nil {
+		return probeKey{}, err
+	}
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return probeKey{}, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return probeKey{}, err
+	}
+	return probeKey{path: path, size: st.Size(), modTime: st.ModTime()}, nil
+}
+
+// executableHasVersion returns a check that an executable reports exactly wantVersion.
+func (e *ensurer) executableHasVersion(wantVersion *version.Version, prefixes ...string) versionCheck {
+	return e.executableSatisfies(versionRequirement{"==" + wantVersion.String(), wantVersion.Equal}, prefixes...)
+}
+
+// parseVersionOutput returns the version reported in --version output by the first line
+// matching one of prefixes, or nil.  An empty prefix matches a line holding nothing but a
+// version, as printed by micromamba and mamba 2.x, so that banners and other tools' versions
+// are skipped.
+func parseVersionOutput(output string, prefixes ...string) *version.Version {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	for _, line := range lines {
+		for _, prefix := range prefixes {
+			if exeVersion := parseVersionLine(line, prefix); exeVersion != nil {
+				return exeVersion
+			}
+		}
+	}
+	return nil
+}
+
+var ansiEscapeRegex = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+var versionTokenRegex = regexp.MustCompile(`\d+(\.\d+)+([.-]?(a|b|rc|alpha|beta|post|dev)\d*)*`)
+
+// parseVersionLine extracts the version from a line of --version output starting with
+// prefix, e.g. "conda 23.11.0".  Color codes and surrounding text are ignored.
+func parseVersionLine(line string, prefix string) *version.Version {
+	line = strings.TrimSpace(ansiEscapeRegex.ReplaceAllString(line, ""))
+	if !strings.HasPrefix(line, prefix) {
+		return nil
+	}
+	token := versionTokenRegex.FindString(line[len(prefix):])
+	if token == "" || (prefix == "" && token != line) {
+		return nil
+	}
+	exeVersion, err := version.NewVersion(token)
+	if err != nil {
+		return nil
+	}
+	return exeVersion
+}
+
+// selfTest checks that an installed executable can run a command by having it print its
+// configuration.  A failing executable is removed so that it is downloaded again.
+func (e *ensurer) selfTest(ctx context.Context, executable string) error {
+	if err := e.runSelfTest(ctx, executable); err != nil {
+		_ = os.Remove(executable)
+		_ = os.Remove(metadataFileName(executable))
+		return err
+	}
+	return nil
+}
+
+// runSelfTest is selfTest leaving a failing executable in place, for ones not installed by
+// ensureconda.
+func (e *ensurer) runSelfTest(ctx context.Context, executable string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, executable, "info", "--json")
+	cmd.Stderr = &stderr
+	stdout, err := cmd.Output()
+	if err == nil && !json.Valid(stdout) {
+		err = errors.New("output is not valid JSON")
+	}
+	if err != nil {
+		return fmt.Errorf("self-test of %s failed: %v: %s", executable, err, strings.TrimSpace(stderr.String()))
+	}
+	e.log.WithField("executable", executable).Debug("self-test passed")
+	return nil
+}
+
+func (e *ensurer) resolveExecutable(ctx context.Context, executableName string, dataDir string, check versionCheck) (string, *version.Version, error) {
+	path := os.Getenv("PATH")
+	var filteredPaths []string
+	if e.dataDirSearchedFirst() {
+		filteredPaths = append(filteredPaths, dataDir)
+	}
+
+	if e.opts.NoPathSearch {
+		path = ""
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if e.opts.NoShimFiltering || !isPyenvShimDir(dir) {
+			filteredPaths = append(filteredPaths, dir)
+		}
+	}
+	if e.opts.DataDirSearch == DataDirLast {
+		filteredPaths = append(filteredPaths, dataDir)
+	}
+	newPathEnv := strings.Join(filteredPaths, string(os.PathListSeparator))
+	return e.findExecutable(ctx, executableName, newPathEnv, check)
+}
+
+// checkDataDirSearch validates Options.DataDirSearch.
+func checkDataDirSearch(dataDirSearch string) error {
+	switch dataDirSearch {
+	case "", DataDirFirst, DataDirLast, DataDirNever:
+		return nil
+	}
+	return fmt.Errorf("invalid data directory search %q, expected %s, %s or %s", dataDirSearch, DataDirFirst, DataDirLast, DataDirNever)
+}
+
+// dataDirSearchedFirst reports whether executables in the data directory take precedence
+// over ones on PATH.
+func (e *ensurer) dataDirSearchedFirst() bool {
+	return e.opts.DataDirSearch == "" || e.opts.DataDirSearch == DataDirFirst
+}
+
+// isPyenvShimDir reports whether dir is within the shims of pyenv, .pyenv/shims, or of
+// pyenv-win, .pyenv\pyenv-win\shims.  Path elements are compared case-insensitively on
+// Windows.
+func isPyenvShimDir(dir string) bool {
+	elems := strings.FieldsFunc(dir, func(r rune) bool {
+		return r == '/' || r == filepath.Separator
+	})
+	same := func(a, b string) bool {
+		if runtime.GOOS == "windows" {
+			return strings.EqualFold(a, b)
+		}
+		return a == b
+	}
+	for i := range elems {
+		if !same(elems[i], ".pyenv") {
+			continue
+		}
+		rest := elems[i+1:]
+		if len(rest) > 0 && same(rest[0], "pyenv-win") {
+			rest = rest[1:]
+		}
+		if len(rest) > 0 && same(rest[0], "shims") {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *ensurer) findExecutable(ctx context.Context, executableFileName string, searchPath string, check versionCheck) (string, *version.Version, error) {
+	e.log.
+		WithField("searchPath", searchPath).
+		WithField("executable", executableFileName).
+		Debug("Searching for executable")
+	var paths []string
+	for _, dir := range filepath.SplitList(searchPath) {
+		if dir == "" {
+			// Unix shell semantics: searchPath element "" means "."
+			dir = "."
+		}
+		for _, ext := range executableExtensions(executableFileName) {
+			paths = append(paths, filepath.Join(dir, executableFileName+ext))
+		}
+	}
+
+	concurrency := e.opts.ProbeConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultProbeConcurrency
+	}
+	notFound := &executableNotFoundError{name: executableFileName}
+	if concurrency == 1 {
+		for _, path := range paths {
+			result := probeExecutable(ctx, path, check)
+			if result.ok {
+				return path, result.version, nil
+			}
+			notFound.reject(path, result)
+		}
+		return "", nil, notFound
+	}
+
+	// Probe up to concurrency paths ahead, taking results in PATH order so that the
+	// earliest suitable executable wins.  Probes already running when it is found are
+	// left to finish in the background.
+	results := make([]chan probeResult, len(paths))
+	for i := range results {
+		results[i] = make(chan probeResult, 1)
+	}
+	slots := make(chan struct{}, concurrency)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, path := range paths {
+			select {
+			case slots <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int, path string) {
+				defer func() { <-slots }()
+				results[i] <- probeExecutable(ctx, path, check)
+			}(i, path)
+		}
+	}()
+	for i, path := range paths {
+		result := <-results[i]
+		if result.ok {
+			return path, result.version, nil
+		}
+		notFound.reject(path, result)
+	}
+	return "", nil, notFound
+}
+
+// probeResult is the outcome of checking one candidate path.
+type probeResult struct {
+	// present is set when path is an executable, whether or not it passed the check.
+	present bool
+	version *version.Version
+	ok      bool
+	// err is why path couldn't be checked, other than it not existing.
+	err error
+}
+
+// probeExecutable reports whether path is an executable passing check, and its version.
+func probeExecutable(ctx context.Context, path string, check versionCheck) probeResult {
+	if err := assertExecutable(path); err != nil {
+		if os.IsNotExist(err) {
+			return probeResult{}
+		}
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return probeResult{err: err}
+	}
+	exeVersion, result, err := check(ctx, path)
+	return probeResult{present: true, version: exeVersion, ok: err == nil && result, err: err}
+}
+
+// RejectedExecutable is an executable found while searching that wasn't used, as it is
+// older than the minimum version or its version couldn't be determined.
+type RejectedExecutable struct {
+	Executable string
+	// Version is nil when it couldn't be determined.
+	Version *version.Version
+}
+
+// executableNotFoundError is returned by findExecutable when no suitable executable exists,
+// listing the ones that were found but rejected in PATH order, and the paths that couldn't
+// be checked.
+type executableNotFoundError struct {
+	name     string
+	rejected []RejectedExecutable
+	problems []error
+}
+
+func (err *executableNotFoundError) reject(path string, result probeResult) {
+	if result.present {
+		err.rejected = append(err.rejected, RejectedExecutable{Executable: path, Version: result.version})
+	}
+	if result.err != nil {
+		err.problems = append(err.problems, fmt.Errorf("%s: %v", path, result.err))
+	}
+}
+
+func (err *executableNotFoundError) Error() string {
+	if len(err.rejected) == 0 {
+		return fmt.Sprintf("could not find executable %s", err.name)
+	}
+	return fmt.Sprintf("could not find a suitable executable %s, rejected %d", err.name, len(err.rejected))
+}
+
+// searchProblems returns the errors met by the search that failed with err, such as a PATH
+// directory that can't be read or an executable whose --version fails.
+func searchProblems(err error) []error {
+	var notFound *executableNotFoundError
+	if errors.As(err, &notFound) {
+		return notFound.problems
+	}
+	return nil
+}
+
+// rejectedExecutables returns the executables passed over by the search that failed with err.
+func rejectedExecutables(err error) []RejectedExecutable {
+	var notFound *executableNotFoundError
+	if errors.As(err, &notFound) {
+		return notFound.rejected
+	}
+	return nil
+}
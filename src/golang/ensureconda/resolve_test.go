@@ -0,0 +1,564 @@
+package ensureconda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-version"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVersionLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		prefix string
+		want   string
+	}{
+		{"conda", "conda 23.11.0", "conda", "23.11.0"},
+		{"conda old", "conda 4.8.2", "conda", "4.8.2"},
+		{"conda windows line ending", "conda 4.9.2\r", "conda", "4.9.2"},
+		{"conda prerelease", "conda 24.1.0rc1", "conda", "24.1.0rc1"},
+		{"conda with colors", "\x1b[32mconda\x1b[0m 23.11.0", "conda", "23.11.0"},
+		{"conda trailing text", "conda 4.10.3 (py39)", "conda", "4.10.3"},
+		{"mamba v1", "mamba 1.5.8", "mamba", "1.5.8"},
+		{"mamba v1 conda line", "conda 24.3.0", "mamba", ""},
+		{"mamba v2", "2.0.5", "", "2.0.5"},
+		{"micromamba", "1.5.8", "", "1.5.8"},
+		{"micromamba padded", "  0.27.0  ", "", "0.27.0"},
+		{"bare style skips prefixed lines", "conda 24.3.0", "", ""},
+		{"empty", "", "", ""},
+		{"no version", "conda: command not found", "conda", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseVersionLine(tt.line, tt.prefix)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("parseVersionLine() got = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Original() != tt.want {
+				t.Errorf("parseVersionLine() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseVersionOutput(t *testing.T) {
+	mambaStyles := []string{"mamba", ""}
+	micromambaStyles := []string{"micromamba", ""}
+	banner := `
+                                           __
+          __  ______ ___  ____ _____ ___  / /_  ____ _
+         / / / / __ ` + "`" + `__ \/ __ ` + "`" + `/ __ ` + "`" + `__ \/ __ \/ __ ` + "`" + `/
+        / /_/ / / / / / / /_/ / / / / / / /_/ / /_/ /
+       / .___/_/ /_/ /_/\__,_/_/ /_/ /_/_.___/\__,_/
+      /_/
+`
+	tests := []struct {
+		name     string
+		output   string
+		prefixes []string
+		want     string
+	}{
+		{"micromamba 0.x", "0.27.0\n", micromambaStyles, "0.27.0"},
+		{"micromamba 1.x", "1.5.8\n", micromambaStyles, "1.5.8"},
+		{"micromamba 2.x", "2.0.5\n", micromambaStyles, "2.0.5"},
+		{"micromamba windows", "1.5.8\r\n", micromambaStyles, "1.5.8"},
+		{"micromamba with banner", banner + "1.4.2\n", micromambaStyles, "1.4.2"},
+		{"micromamba prefixed", "micromamba 0.7.3\n", micromambaStyles, "0.7.3"},
+		{"mamba 0.x", "mamba 0.15.3\nconda 4.10.3\n", mambaStyles, "0.15.3"},
+		{"mamba 1.x", "mamba 1.5.8\nconda 24.3.0\n", mambaStyles, "1.5.8"},
+		{"mamba 2.x", "2.0.5\n", mambaStyles, "2.0.5"},
+		{"conda", "conda 23.11.0\n", []string{"conda"}, "23.11.0"},
+		{"conda is not mamba", "conda 24.3.0\n", mambaStyles, ""},
+		{"nothing", "", micromambaStyles, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseVersionOutput(tt.output, tt.prefixes...)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("parseVersionOutput() got = %v, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.Original() != tt.want {
+				t.Errorf("parseVersionOutput() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// writeFakeConda writes a shell script to dir that reports itself as conda at the given version.
+func writeFakeConda(t *testing.T, dir string, name string, condaVersion string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	script := fmt.Sprintf("#!/bin/sh\necho conda %s\n", condaVersion)
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolveExecutableShimFiltering(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	shims := filepath.Join(opts.DataDir, "home", ".pyenv", "shims")
+	shimConda := writeFakeConda(t, shims, "conda", "4.9.2")
+
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", strings.Join([]string{shims, os.Getenv("PATH")}, string(os.PathListSeparator)))
+
+	minVersion, _ := version.NewVersion("4.9.0")
+	emptyDataDir := filepath.Join(opts.DataDir, "data")
+
+	e := newEnsurer(opts)
+	got, _, _ := e.resolveExecutable(context.Background(), "conda", emptyDataDir, e.executableHasMinVersion(minVersion, "conda"))
+	if got == shimConda {
+		t.Errorf("resolveExecutable() returned the pyenv shim %v", got)
+	}
+
+	opts.NoShimFiltering = true
+	e = newEnsurer(opts)
+	got, gotVersion, err := e.resolveExecutable(context.Background(), "conda", emptyDataDir, e.executableHasMinVersion(minVersion, "conda"))
+	if err != nil || got != shimConda || gotVersion.String() != "4.9.2" {
+		t.Errorf("resolveExecutable() = %v, %v, want %v", got, err, shimConda)
+	}
+}
+
+func TestResolveExecutableDataDirSearch(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	dataDir := filepath.Join(opts.DataDir, "data")
+	binDir := filepath.Join(opts.DataDir, "bin")
+	managedConda := writeFakeConda(t, dataDir, "conda", "4.9.2")
+	systemConda := writeFakeConda(t, binDir, "conda", "4.10.3")
+
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", binDir)
+	minVersion, _ := version.NewVersion("4.9.0")
+
+	tests := []struct {
+		dataDirSearch string
+		noPathSearch  bool
+		want          string
+	}{
+		{"", false, managedConda},
+		{DataDirFirst, false, managedConda},
+		{DataDirLast, false, systemConda},
+		{DataDirLast, true, managedConda},
+		{DataDirNever, false, systemConda},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%q no path search %v", tt.dataDirSearch, tt.noPathSearch), func(t *testing.T) {
+			opts.DataDirSearch = tt.dataDirSearch
+			opts.NoPathSearch = tt.noPathSearch
+			e := newEnsurer(opts)
+			got, _, err := e.resolveExecutable(context.Background(), "conda", dataDir, e.executableHasMinVersion(minVersion, "conda"))
+			if err != nil || got != tt.want {
+				t.Errorf("resolveExecutable() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	opts.DataDirSearch = DataDirNever
+	opts.NoPathSearch = false
+	os.Setenv("PATH", "")
+	e := newEnsurer(opts)
+	if got, _, _ := e.resolveExecutable(context.Background(), "conda", dataDir, e.executableHasMinVersion(minVersion, "conda")); got != "" {
+		t.Errorf("resolveExecutable() with DataDirSearch never = %v, want nothing found", got)
+	}
+	opts.DataDirSearch = "sometimes"
+	if _, err := Resolve(context.Background(), opts); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve() with an invalid DataDirSearch error = %v, want it rejected", err)
+	}
+}
+
+func TestIsPyenvShimDir(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"/home/user/.pyenv/shims", true},
+		{"/home/user/.pyenv/shims/", true},
+		{"/home/user/.pyenv/pyenv-win/shims", true},
+		{"/home/user/.pyenv/versions/3.11.4/bin", false},
+		{"/home/user/not.pyenv/shims", false},
+		{"/opt/shims", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			if got := isPyenvShimDir(filepath.FromSlash(tt.dir)); got != tt.want {
+				t.Errorf("isPyenvShimDir() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveExecutableNoPathSearch(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	onPath := writeFakeConda(t, filepath.Join(opts.DataDir, "bin"), "conda", "4.9.2")
+	dataDir := filepath.Join(opts.DataDir, "data")
+
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Dir(onPath))
+	minVersion, _ := version.NewVersion("4.9.0")
+
+	opts.NoPathSearch = true
+	e := newEnsurer(opts)
+	if got, _, err := e.resolveExecutable(context.Background(), "conda", dataDir, e.executableHasMinVersion(minVersion, "conda")); err == nil {
+		t.Errorf("resolveExecutable() = %v, want nothing found outside the data directory", got)
+	}
+
+	inDataDir := writeFakeConda(t, dataDir, "conda", "4.9.2")
+	got, _, err := e.resolveExecutable(context.Background(), "conda", dataDir, e.executableHasMinVersion(minVersion, "conda"))
+	if err != nil || got != inDataDir {
+		t.Errorf("resolveExecutable() = %v, %v, want %v", got, err, inDataDir)
+	}
+}
+
+func TestFindExecutableKeepsPathOrder(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	var dirs []string
+	for i, condaVersion := range []string{"", "4.8.0", "4.9.2", "4.10.3", "4.11.0"} {
+		dir := filepath.Join(opts.DataDir, strconv.Itoa(i))
+		dirs = append(dirs, dir)
+		if condaVersion != "" {
+			writeFakeConda(t, dir, "conda", condaVersion)
+		}
+	}
+	searchPath := strings.Join(dirs, string(os.PathListSeparator))
+	want := filepath.Join(dirs[2], "conda")
+
+	minVersion, _ := version.NewVersion("4.9.0")
+	for _, concurrency := range []int{0, 1, 2, 8} {
+		t.Run(strconv.Itoa(concurrency), func(t *testing.T) {
+			opts.ProbeConcurrency = concurrency
+			e := newEnsurer(opts)
+			versionCheck := e.executableHasMinVersion(minVersion, "conda")
+			// the wanted executable answers last, so concurrent probes find the later ones first
+			check := func(ctx context.Context, executable string) (*version.Version, bool, error) {
+				if executable == want {
+					time.Sleep(50 * time.Millisecond)
+				}
+				return versionCheck(ctx, executable)
+			}
+
+			got, gotVersion, err := e.findExecutable(context.Background(), "conda", searchPath, check)
+			if err != nil || got != want || gotVersion.String() != "4.9.2" {
+				t.Errorf("findExecutable() = %v, %v, %v, want %v", got, gotVersion, err, want)
+			}
+		})
+	}
+}
+
+func TestVersionProbeCache(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	runs := filepath.Join(opts.DataDir, "runs")
+	exe := filepath.Join(opts.DataDir, "conda")
+	writeScript := func(condaVersion string) {
+		script := fmt.Sprintf("#!/bin/sh\necho run >> %s\necho conda %s\n", runs, condaVersion)
+		if err := ioutil.WriteFile(exe, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	countRuns := func() int {
+		content, _ := ioutil.ReadFile(runs)
+		return strings.Count(string(content), "run")
+	}
+	writeScript("4.9.2")
+	link := filepath.Join(opts.DataDir, "conda_standalone")
+	if err := os.Symlink(exe, link); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newEnsurer(opts)
+	minVersion, _ := version.NewVersion("4.9.0")
+	newerVersion, _ := version.NewVersion("4.10.0")
+	for _, executable := range []string{exe, link, exe} {
+		if _, ok, err := e.executableHasMinVersion(minVersion, "conda")(context.Background(), executable); !ok || err != nil {
+			t.Errorf("executableHasMinVersion() = %v, %v, want true", ok, err)
+		}
+	}
+	if _, ok, _ := e.executableHasMinVersion(newerVersion, "conda")(context.Background(), link); ok {
+		t.Errorf("executableHasMinVersion() accepted 4.9.2 for minimum version %v", newerVersion)
+	}
+	if got := countRuns(); got != 1 {
+		t.Errorf("executableHasMinVersion() ran the executable %d times, want 1", got)
+	}
+
+	// an install replacing the executable is probed afresh
+	writeScript("4.10.30")
+	if gotVersion, ok, _ := e.executableHasMinVersion(newerVersion, "conda")(context.Background(), exe); !ok || gotVersion.String() != "4.10.30" {
+		t.Errorf("executableHasMinVersion() = %v, %v, want the replaced executable's version", gotVersion, ok)
+	}
+	if got := countRuns(); got != 2 {
+		t.Errorf("executableHasMinVersion() ran the executable %d times, want 2", got)
+	}
+}
+
+func TestResolveReportsFlavorAndVersion(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	exe := writeFakeConda(t, opts.DataDir, "conda_standalone", "4.10.3")
+	opts.CondaStandalone = true
+	opts.NoInstall = true
+
+	got, err := Resolve(context.Background(), opts)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got.Executable != exe || got.Flavor != CondaStandalone || got.Version.String() != "4.10.3" {
+		t.Errorf("Resolve() got = %+v, want %s %s 4.10.3", got, exe, CondaStandalone)
+	}
+}
+
+func TestResolveReportsSearchProblems(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	exe := filepath.Join(opts.DataDir, "conda_standalone")
+	if err := ioutil.WriteFile(exe, []byte("#!/bin/sh\necho broken >&2\nexit 3\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts.CondaStandalone = true
+	opts.NoInstall = true
+	opts.NoPathSearch = true
+
+	_, err := Resolve(context.Background(), opts)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Resolve() error = %v, want ErrNotFound", err)
+	}
+	if !strings.Contains(err.Error(), exe) || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Resolve() error = %v, want it to explain why %s was not used", err, exe)
+	}
+
+	if err := os.Remove(exe); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Resolve(context.Background(), opts); err != ErrNotFound {
+		t.Errorf("Resolve() error = %v, want ErrNotFound when nothing was found", err)
+	}
+}
+
+func TestVersionOnStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	exe := filepath.Join(opts.DataDir, "conda")
+	if err := ioutil.WriteFile(exe, []byte("#!/bin/sh\necho conda 23.11.0 >&2\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	minVersion, _ := version.NewVersion("4.8.2")
+	gotVersion, ok, err := newEnsurer(opts).executableHasMinVersion(minVersion, "conda")(context.Background(), exe)
+	if err != nil || !ok || gotVersion.String() != "23.11.0" {
+		t.Errorf("executableHasMinVersion() = %v, %v, %v, want 23.11.0 read from stderr", gotVersion, ok, err)
+	}
+}
+
+func TestVersionProbeCancelled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	opts.NoPathSearch = true
+	exe := filepath.Join(opts.DataDir, "conda")
+	if err := ioutil.WriteFile(exe, []byte("#!/bin/sh\nexec sleep 60\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// a hung executable doesn't outlast the context of the search
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	e := newEnsurer(opts)
+	minVersion, _ := version.NewVersion("4.8.2")
+	got, _, err := e.resolveExecutable(ctx, "conda", opts.DataDir, e.executableHasMinVersion(minVersion, "conda"))
+	if got != "" || err == nil {
+		t.Errorf("resolveExecutable() = %v, %v, want the hung executable rejected", got, err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("resolveExecutable() took %v, want it stopped with its context", elapsed)
+	}
+}
+
+func TestCheckExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	dir, err := ioutil.TempDir("", "ensureconda-check-executable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name        string
+		output      string
+		flavor      Flavor
+		wantVersion string
+		wantOk      bool
+		wantErr     bool
+	}{
+		{"conda", "conda 23.11.0", Conda, "23.11.0", true, false},
+		{"old conda", "conda 4.6.0", CondaStandalone, "4.6.0", false, false},
+		{"mamba 1.x", "mamba 1.5.8\nconda 23.11.0", Mamba, "1.5.8", true, false},
+		{"mamba 2.x", "2.0.5", Mamba, "2.0.5", true, false},
+		{"micromamba", "1.5.10", Micromamba, "1.5.10", true, false},
+		{"no version", "usage: conda", Conda, "", false, false},
+		{"unknown flavor", "conda 23.11.0", Flavor("pixi"), "", false, true},
+		{"missing executable", "", Conda, "", false, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exe := filepath.Join(dir, strconv.Itoa(i))
+			if tt.output != "" {
+				if err := ioutil.WriteFile(exe, []byte("#!/bin/sh\nprintf '"+tt.output+"\\n'\n"), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			gotVersion, gotOk, err := CheckExecutable(exe, tt.flavor)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckExecutable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if gotVersion != tt.wantVersion || gotOk != tt.wantOk {
+				t.Errorf("CheckExecutable() got = %q, %v, want %q, %v", gotVersion, gotOk, tt.wantVersion, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestSpecVersionRequirement(t *testing.T) {
+	tests := []struct {
+		spec    string
+		version string
+		want    bool
+		wantErr bool
+	}{
+		{"4.8.2", "4.8.2", true, false},
+		{"4.8.2", "4.8.1", false, false},
+		{">=23.1,<24", "23.11.0", true, false},
+		{">=23.1,<24", "24.1.0", false, false},
+		{">=23.1, <24, !=23.5.0", "23.5.0", false, false},
+		{"==23.11.0", "23.11.0", true, false},
+		{"==23.*", "23.11.0", true, false},
+		{"==23.*", "24.1.0", false, false},
+		{"~=23.1", "23.11.0", true, false},
+		{"~=23.1", "24.1.0", false, false},
+		{">=24", "24.1.0rc1", false, false},
+		{">=24.1.0rc1", "24.1.0rc2", true, false},
+		{"===23.1", "", false, true},
+		{"!=23.*", "", false, true},
+		{">=foo", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec+" "+tt.version, func(t *testing.T) {
+			got, err := specVersionRequirement(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("specVersionRequirement() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if accepts := got.accepts(version.Must(version.NewVersion(tt.version))); accepts != tt.want {
+				t.Errorf("specVersionRequirement(%q) accepts %v = %v, want %v", tt.spec, tt.version, accepts, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveCondaVersionSpec(t *testing.T) {
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	exe := writeFakeConda(t, opts.DataDir, "conda_standalone", "24.3.0")
+	opts.CondaStandalone = true
+	opts.NoInstall = true
+
+	tests := []struct {
+		spec    string
+		want    string
+		wantErr bool
+	}{
+		{"", exe, false},
+		{">=23.1,<25", exe, false},
+		{">=23.1,<24", "", true},
+		{"==24.*", exe, false},
+		{"=>24", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			opts.CondaVersionSpec = tt.spec
+			got, err := Resolve(context.Background(), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Executable != tt.want {
+				t.Errorf("Resolve() got = %v, want %v", got.Executable, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	e := newEnsurer(opts)
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr bool
+	}{
+		{"json", "#!/bin/sh\necho '{\"conda_version\": \"24.1.2\"}'\n", false},
+		{"crash", "#!/bin/sh\necho \"ModuleNotFoundError: No module named '_sysconfigdata'\" >&2\nexit 1\n", true},
+		{"not json", "#!/bin/sh\necho usage: conda\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exe := filepath.Join(opts.DataDir, "conda_standalone")
+			if err := ioutil.WriteFile(exe, []byte(tt.script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			err := e.selfTest(context.Background(), exe)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("selfTest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if _, statErr := os.Stat(exe); tt.wantErr != os.IsNotExist(statErr) {
+				t.Errorf("selfTest() error = %v but executable exists = %v", err, statErr == nil)
+			}
+			if tt.name == "crash" && !strings.Contains(err.Error(), "_sysconfigdata") {
+				t.Errorf("selfTest() error = %v, want it to include stderr", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package ensureconda
+
+import (
+	"context"
+	"github.com/hashicorp/go-version"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsPyenvShimDirWindows(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{`C:\Users\user\.pyenv\pyenv-win\shims`, true},
+		{`C:\Users\user\.pyenv\pyenv-win\shims\`, true},
+		{`C:\Users\user\.PYENV\PYENV-WIN\Shims`, true},
+		{`C:/Users/user/.pyenv/pyenv-win/shims`, true},
+		{`C:\Users\user\.pyenv\pyenv-win\bin`, false},
+		{`C:\Program Files\shims`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			if got := isPyenvShimDir(tt.dir); got != tt.want {
+				t.Errorf("isPyenvShimDir() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := filepath.Join(".pyenv", "pyenv-win", "shims"); !isPyenvShimDir(got) {
+		t.Errorf("isPyenvShimDir() rejected %v", got)
+	}
+}
+
+func TestExecutableExtensions(t *testing.T) {
+	defer os.Setenv("PATHEXT", os.Getenv("PATHEXT"))
+	tests := []struct {
+		pathExt string
+		name    string
+		want    []string
+	}{
+		{"", "conda", []string{".com", ".exe", ".bat", ".cmd"}},
+		{".EXE;.BAT;.CMD", "conda", []string{".exe", ".bat", ".cmd"}},
+		{".EXE;.BAT;.CMD", "conda.exe", []string{""}},
+		{".EXE;.BAT;.CMD", "conda.BAT", []string{""}},
+		{".EXE", "conda.bat", []string{".exe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pathExt+" "+tt.name, func(t *testing.T) {
+			os.Setenv("PATHEXT", tt.pathExt)
+			if got := executableExtensions(tt.name); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("executableExtensions() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindExecutableBatLauncher(t *testing.T) {
+	defer os.Setenv("PATHEXT", os.Getenv("PATHEXT"))
+	os.Setenv("PATHEXT", ".COM;.EXE;.BAT;.CMD")
+	opts := initTetEnv()
+	defer os.RemoveAll(opts.DataDir)
+	condabin := filepath.Join(opts.DataDir, "condabin")
+	if err := os.MkdirAll(condabin, 0700); err != nil {
+		t.Fatal(err)
+	}
+	launcher := filepath.Join(condabin, "conda.bat")
+	if err := ioutil.WriteFile(launcher, []byte("@echo conda 23.11.0\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Not in PATHEXT, so not an executable
+	if err := ioutil.WriteFile(filepath.Join(opts.DataDir, "conda"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := assertExecutable(filepath.Join(opts.DataDir, "conda")); err == nil {
+		t.Errorf("assertExecutable() accepted a file without a PATHEXT extension")
+	}
+
+	minVersion, _ := version.NewVersion("4.8.2")
+	e := newEnsurer(opts)
+	searchPath := opts.DataDir + string(os.PathListSeparator) + condabin
+	got, gotVersion, err := e.findExecutable(context.Background(), "conda", searchPath, e.executableHasMinVersion(minVersion, "conda"))
+	if err != nil {
+		t.Fatalf("findExecutable() error = %v", err)
+	}
+	if got != launcher || gotVersion.String() != "23.11.0" {
+		t.Errorf("findExecutable() got = %v %v, want %v 23.11.0", got, gotVersion, launcher)
+	}
+}
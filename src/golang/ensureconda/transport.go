@@ -0,0 +1,272 @@
+package ensureconda
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"golang.org/x/net/http/httpproxy"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// offline reports whether network access is disabled by Options.Offline or
+// ENSURECONDA_OFFLINE.  Values strconv.ParseBool doesn't understand count as set.
+func (e *ensurer) offline() bool {
+	if e.opts.Offline {
+		return true
+	}
+	value := os.Getenv("ENSURECONDA_OFFLINE")
+	if value == "" {
+		return false
+	}
+	offline, err := strconv.ParseBool(value)
+	return offline || err != nil
+}
+
+// httpClient returns the client used for channel listings and downloads, configured from
+// the proxy and TLS options.  It is built on first use and shared by all requests of a run,
+// so that connections to the same host are kept alive and reused.
+func (e *ensurer) httpClient() (*http.Client, error) {
+	if e.client != nil {
+		return e.client, nil
+	}
+	proxy, err := e.proxyFunc()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := e.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	headers, err := e.authHeaders()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	// Setting a TLS config turns off HTTP/2 unless asked for
+	transport.ForceAttemptHTTP2 = true
+	e.client = &http.Client{Transport: transport, CheckRedirect: e.checkRedirect}
+	e.headers = headers
+	e.authHosts = e.authHostSet()
+	e.userAgent = e.opts.UserAgent
+	if e.userAgent == "" {
+		e.userAgent = os.Getenv("ENSURECONDA_USER_AGENT")
+	}
+	if e.userAgent == "" {
+		e.userAgent = defaultUserAgent()
+	}
+	e.log.WithField("userAgent", e.userAgent).Debug("using user agent")
+	return e.client, nil
+}
+
+// defaultUserAgent names ensureconda, its version and the platform, e.g.
+// "ensureconda/1.4.3 (linux; amd64)", rather than Go's generic default that some mirrors
+// block.
+func defaultUserAgent() string {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		version = strings.TrimPrefix(info.Main.Version, "v")
+	}
+	return fmt.Sprintf("ensureconda/%s (%s; %s)", version, runtime.GOOS, runtime.GOARCH)
+}
+
+// authHeaders collects the headers sent to private mirrors, from Options.AuthHeaders and
+// Options.Token or else ENSURECONDA_AUTH_HEADER and ENSURECONDA_TOKEN.  They are only sent
+// to the hosts of authHostSet, and dropped by checkRedirect on redirects to another host.
+func (e *ensurer) authHeaders() (http.Header, error) {
+	rawHeaders := e.opts.AuthHeaders
+	if len(rawHeaders) == 0 {
+		if header := os.Getenv("ENSURECONDA_AUTH_HEADER"); header != "" {
+			rawHeaders = []string{header}
+		}
+	}
+	token := e.opts.Token
+	if token == "" {
+		token = os.Getenv("ENSURECONDA_TOKEN")
+	}
+
+	headers := http.Header{}
+	for _, raw := range rawHeaders {
+		parts := strings.SplitN(raw, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", redactHeader(raw))
+		}
+		headers.Add(name, strings.TrimSpace(parts[1]))
+	}
+	if token != "" {
+		headers.Set("Authorization", "Bearer "+token)
+	}
+	return headers, nil
+}
+
+// authHostSet returns the hosts auth headers are sent to: those of the configured channels
+// and micromamba mirrors, so that credentials for a private mirror never reach anaconda.org,
+// GitHub or the other fallbacks.  A bare channel name other than the default stands for
+// anaconda.org.
+func (e *ensurer) authHostSet() map[string]bool {
+	hosts := map[string]bool{}
+	addUrl := func(rawUrl string) {
+		if u, err := url.Parse(rawUrl); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			hosts[strings.ToLower(u.Host)] = true
+		}
+	}
+	addChannel := func(channel string) {
+		switch {
+		case isChannelUrl(channel):
+			addUrl(channel)
+		case channel != "" && channel != defaultCondaStandaloneChannel:
+			addUrl(anacondaApiUrl)
+		}
+	}
+	if channel, err := e.getChannelName(); err == nil {
+		addChannel(channel)
+	}
+	addChannel(e.opts.MicromambaChannel)
+	for _, mirror := range e.opts.MicromambaMirrors {
+		addUrl(strings.ReplaceAll(mirror, "{subdir}", e.subdir))
+	}
+	return hosts
+}
+
+// sendsAuthHeaders reports whether auth headers are sent with requests to u.
+func (e *ensurer) sendsAuthHeaders(u *url.URL) bool {
+	return len(e.headers) != 0 && e.authHosts[strings.ToLower(u.Host)]
+}
+
+// checkRedirect follows up to 10 redirects like the default policy, removing auth headers
+// on redirects to another host: the http client only does so for Authorization and
+// cookies, and would forward the others.
+func (e *ensurer) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+		for name := range e.headers {
+			req.Header.Del(name)
+		}
+	}
+	return nil
+}
+
+// redactHeader hides the value of a raw "Name: value" header for logging.
+func redactHeader(raw string) string {
+	if i := strings.Index(raw, ":"); i >= 0 {
+		return raw[:i+1] + " REDACTED"
+	}
+	return "REDACTED"
+}
+
+// headerNames lists the names of headers for logging, leaving out their possibly secret values.
+func headerNames(headers http.Header) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// proxyFunc picks the proxy for each request.  An explicit Options.SocksProxy is used for
+// everything.  Otherwise HTTP_PROXY, HTTPS_PROXY and NO_PROXY apply as usual, with a
+// SOCKS5 proxy in ALL_PROXY standing in for whichever of the first two is unset.
+func (e *ensurer) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if e.opts.SocksProxy != "" {
+		socks, err := parseSocksProxy(e.opts.SocksProxy)
+		if err != nil {
+			return nil, err
+		}
+		return http.ProxyURL(socks), nil
+	}
+
+	config := httpproxy.FromEnvironment()
+	allProxy := os.Getenv("ALL_PROXY")
+	if allProxy == "" {
+		allProxy = os.Getenv("all_proxy")
+	}
+	if allProxy != "" {
+		if socks, err := parseSocksProxy(allProxy); err != nil {
+			// curl also takes http proxies from ALL_PROXY, which HTTP_PROXY covers for us
+			e.log.WithField("proxy", allProxy).WithError(err).Debug("ignoring ALL_PROXY")
+		} else {
+			if config.HTTPProxy == "" {
+				config.HTTPProxy = socks.String()
+			}
+			if config.HTTPSProxy == "" {
+				config.HTTPSProxy = socks.String()
+			}
+		}
+	}
+	proxyForUrl := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyForUrl(req.URL)
+	}, nil
+}
+
+// parseSocksProxy validates a socks5://[user:password@]host:port proxy url.  Host names are
+// always resolved by the proxy, so socks5h:// is accepted as an alias.
+func parseSocksProxy(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socks proxy %q: %w", proxy, err)
+	}
+	switch u.Scheme {
+	case "socks5":
+	case "socks5h":
+		u.Scheme = "socks5"
+	default:
+		return nil, fmt.Errorf("invalid socks proxy %q: scheme must be socks5 or socks5h", proxy)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return nil, fmt.Errorf("invalid socks proxy %q: expected host:port", proxy)
+	}
+	return u, nil
+}
+
+// tlsConfig trusts the system roots plus those in Options.CABundle, ENSURECONDA_CA_BUNDLE
+// or SSL_CERT_FILE, as TLS intercepting proxies present certificates signed by their own
+// root.
+func (e *ensurer) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if e.opts.Insecure {
+		e.log.Warn("TLS certificate verification is disabled; downloads can be tampered with")
+		config.InsecureSkipVerify = true
+		return config, nil
+	}
+
+	bundle := e.opts.CABundle
+	if bundle == "" {
+		bundle = os.Getenv("ENSURECONDA_CA_BUNDLE")
+	}
+	if bundle == "" {
+		bundle = os.Getenv("SSL_CERT_FILE")
+	}
+	if bundle == "" {
+		return config, nil
+	}
+
+	pem, err := ioutil.ReadFile(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", bundle)
+	}
+	e.log.WithField("caBundle", bundle).Debug("trusting additional root certificates")
+	config.RootCAs = pool
+	return config, nil
+}
@@ -36,7 +36,7 @@ def test_install(golang_exe, flags):
     args = [golang_exe, "--verbosity=3"]
     args.extend(flags)
     print(args)
-    result = subprocess.check_output(args, encoding="utf8")
+    result = subprocess.check_output(args, encoding="utf8").rstrip("\n")
     subprocess.check_call([result, "--help"])
 
 